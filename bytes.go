@@ -0,0 +1,41 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import "unsafe"
+
+// AddBytes adds a key held in a byte slice and its associated value data to
+// the prefix tree, exactly as Add would add the key's string form. The tree
+// stores the key, so it is copied once; the caller may reuse the slice after
+// AddBytes returns.
+func (t *Tree[V]) AddBytes(key []byte, value V) {
+	t.Add(string(key), value)
+}
+
+// FindValueBytes searches the prefix tree for a key string that uniquely
+// matches a prefix held in a byte slice, exactly as FindValue would search
+// for the prefix's string form. Unless the tree's queries are being timed or
+// counted, or the tree has a splitter that could retain the strings it is
+// passed, the prefix is matched in place without being copied to a string,
+// so the search doesn't allocate.
+func (t *Tree[V]) FindValueBytes(prefix []byte) (V, error) {
+	if t.opts.observing() || t.opts.isSplitting() {
+		return t.FindValue(string(prefix))
+	}
+
+	st, err := t.findSubtree(bytesString(prefix))
+	if err != nil {
+		var empty V
+		return empty, err
+	}
+	return st.value, nil
+}
+
+// bytesString returns a string sharing the memory of b. The string must not
+// be retained beyond the call it is passed to, since b may be modified
+// afterward.
+func bytesString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
@@ -0,0 +1,73 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	cases := []struct {
+		prefix string
+		value  int
+		err    error
+	}{
+		{"", 0, ErrPrefixAmbiguous},
+		{"ap", 0, ErrPrefixAmbiguous},
+		{"apple", 1, nil},
+		{"applep", 2, nil},
+		{"ar", 3, nil},
+		{"b", 4, nil},
+		{"c", 0, ErrPrefixNotFound},
+	}
+
+	for _, opts := range representations {
+		// Keys added as byte slices and as strings share one keyspace.
+		tree := New[int](opts...)
+		buf := []byte("apple")
+		tree.AddBytes(buf, 1)
+		copy(buf, "xxxxx") // the tree holds its own copy of the key
+		tree.Add("applepie", 2)
+		tree.AddBytes([]byte("arm"), 3)
+		tree.Add("bee", 4)
+
+		for i, c := range cases {
+			v1, err1 := tree.FindValueBytes([]byte(c.prefix))
+			v2, err2 := tree.FindValue(c.prefix)
+			if v1 != c.value || err1 != c.err || v2 != c.value || err2 != c.err {
+				t.Errorf("Case %d: FindValueBytes(\"%s\") returned (%d, %v) and FindValue returned (%d, %v), expected (%d, %v).\n",
+					i, c.prefix, v1, err1, v2, err2, c.value, c.err)
+			}
+		}
+
+		prefix := []byte("applep")
+		allocs := testing.AllocsPerRun(100, func() { tree.FindValueBytes(prefix) })
+		if allocs != 0 {
+			t.Errorf("FindValueBytes allocated %v times, expected 0.\n", allocs)
+		}
+	}
+}
+
+func TestBytesSplitter(t *testing.T) {
+	// A splitter may keep the strings it is passed, so they must not change
+	// when the caller reuses the prefix's byte slice.
+	var seen []string
+	tree := New[int](WithSplitter(func(s string) []string {
+		seen = append(seen, s)
+		return strings.Split(s, ".")
+	}))
+	tree.Add("a.b", 1)
+	tree.Add("a.bc", 2)
+
+	prefix := []byte("a.b")
+	if v, err := tree.FindValueBytes(prefix); v != 1 || err != nil {
+		t.Errorf("FindValueBytes(\"a.b\") returned (%d, %v), expected (1, nil).\n", v, err)
+	}
+	copy(prefix, "xyz")
+	if s := seen[len(seen)-1]; s != "a.b" {
+		t.Errorf("Splitter's string became %q after the prefix was reused, expected \"a.b\".\n", s)
+	}
+}
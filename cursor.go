@@ -0,0 +1,105 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+// A Cursor steps through the keys of a prefix tree one at a time, in
+// lexicographic key order, for instance to merge them with another sorted
+// source. A cursor keeps its position in an explicit stack of the nodes
+// between the root and the next key, so stepping it neither recurses nor
+// allocates once the stack has grown to the depth of the tree. A cursor must
+// not be used after its tree is modified.
+type Cursor[V any] struct {
+	tree  *Tree[V]
+	stack []cursorFrame[V]
+	next  *Tree[V] // terminal node holding the next key, if already found
+}
+
+// A cursorFrame records a cursor's progress through the links of a node.
+type cursorFrame[V any] struct {
+	node *Tree[V]
+	ix   int // index of the next link to follow, or -1 before the node's key
+}
+
+// Cursor returns a cursor positioned at the first key of the prefix tree.
+func (t *Tree[V]) Cursor() *Cursor[V] {
+	c := &Cursor[V]{tree: t, stack: make([]cursorFrame[V], 0, 16)}
+	c.Seek("")
+	return c
+}
+
+// Next returns the key and value at the cursor's position and advances the
+// cursor to the following key. If the cursor has passed the last key, Next
+// returns false.
+func (c *Cursor[V]) Next() (KeyValue[V], bool) {
+	kv, ok := c.Peek()
+	c.next = nil
+	return kv, ok
+}
+
+// Peek returns the key and value at the cursor's position without advancing
+// the cursor. If the cursor has passed the last key, Peek returns false.
+func (c *Cursor[V]) Peek() (KeyValue[V], bool) {
+	c.fill()
+	if c.next == nil {
+		return KeyValue[V]{}, false
+	}
+	return KeyValue[V]{c.next.key, c.next.value}, true
+}
+
+// Seek positions the cursor at the first key in the prefix tree that is
+// greater than or equal to key. Seeking descends the tree only along the
+// path toward key, skipping every key ordered before it.
+func (c *Cursor[V]) Seek(key string) {
+	c.stack, c.next = c.stack[:0], nil
+	n := c.tree
+	for k := c.tree.opts.fold(key); len(k) > 0; {
+		// The node's own key, if any, is a prefix of key and so orders
+		// before it. Find the first link whose keys may not.
+		ix := 0
+		for ; ix < len(n.links); ix++ {
+			seg := n.links[ix].keyseg
+			m := min(len(seg), len(k))
+			if seg[:m] >= k[:m] {
+				break
+			}
+		}
+		if ix == len(n.links) {
+			return
+		}
+
+		// Descend into a link whose key segment is a prefix of key, since
+		// some of its keys may order before key. Every key beneath any other
+		// link orders after key.
+		seg := n.links[ix].keyseg
+		if len(seg) > len(k) || seg != k[:len(seg)] {
+			c.stack = append(c.stack, cursorFrame[V]{n, ix})
+			return
+		}
+		c.stack = append(c.stack, cursorFrame[V]{n, ix + 1})
+		n, k = n.links[ix].tree, k[len(seg):]
+	}
+	c.stack = append(c.stack, cursorFrame[V]{n, -1})
+}
+
+// fill finds the terminal node holding the key at the cursor's position, if
+// it hasn't been found already and the cursor hasn't passed the last key.
+func (c *Cursor[V]) fill() {
+	for c.next == nil && len(c.stack) > 0 {
+		f := &c.stack[len(c.stack)-1]
+		switch {
+		case f.ix < 0:
+			f.ix = 0
+			if f.node.isTerminal() {
+				c.next = f.node
+			}
+		case f.ix < len(f.node.links):
+			child := f.node.links[f.ix].tree
+			f.ix++
+			c.stack = append(c.stack, cursorFrame[V]{child, -1})
+		default:
+			c.stack = c.stack[:len(c.stack)-1]
+		}
+	}
+}
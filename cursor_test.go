@@ -0,0 +1,90 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+
+	cases := []struct {
+		seek string
+		keys []string
+	}{
+		{"", keys},
+		{"a", keys},
+		{"aa", keys[1:]},
+		{"apple", keys[1:]},
+		{"applea", keys[2:]},
+		{"applepie", keys[2:]},
+		{"applepies", keys[3:]},
+		{"applez", keys[3:]},
+		{"ar", keys[4:]},
+		{"armz", keys[6:]},
+		{"b", keys[6:]},
+		{"bef", keys[7:]},
+		{"bog", keys[7:]},
+		{"boga", []string{}},
+		{"c", []string{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for _, i := range rand.Perm(len(keys)) {
+			tree.Add(keys[i], i)
+		}
+
+		c := tree.Cursor()
+		for i, tc := range cases {
+			c.Seek(tc.seek)
+			got := []string{}
+			for {
+				peeked, ok1 := c.Peek()
+				kv, ok2 := c.Next()
+				if peeked != kv || ok1 != ok2 {
+					t.Errorf("Case %d: Peek returned (%v, %v) but Next returned (%v, %v).\n",
+						i, peeked, ok1, kv, ok2)
+				}
+				if !ok2 {
+					break
+				}
+				if kv.Value != slices.Index(keys, kv.Key) {
+					t.Errorf("Case %d: Next returned value %d for key %q.\n", i, kv.Value, kv.Key)
+				}
+				got = append(got, kv.Key)
+			}
+			if !slices.Equal(got, tc.keys) {
+				t.Errorf("Case %d: after Seek(%q), the cursor returned %v, expected %v.\n",
+					i, tc.seek, got, tc.keys)
+			}
+		}
+
+		// A new cursor starts at the first key, and stepping it doesn't
+		// allocate.
+		c = tree.Cursor()
+		if kv, ok := c.Peek(); kv.Key != "a" || !ok {
+			t.Errorf("Peek on a new cursor returned (%v, %v).\n", kv, ok)
+		}
+		allocs := testing.AllocsPerRun(10, func() {
+			for c.Seek(""); ; {
+				if _, ok := c.Next(); !ok {
+					break
+				}
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("Stepping the cursor allocated %v times, expected 0.\n", allocs)
+		}
+	}
+
+	// A cursor over an empty tree has no keys.
+	if kv, ok := New[int]().Cursor().Next(); ok {
+		t.Errorf("Next on an empty tree's cursor returned %v.\n", kv)
+	}
+}
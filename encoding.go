@@ -0,0 +1,526 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// MarshalCompact serializes the keys and values of the prefix tree into a
+// compact binary form, using encodeVal to encode each value. Identical value
+// encodings are stored only once and referenced by index from each key, so
+// trees with many keys but few distinct values serialize to a fraction of
+// the size of a naive key/value encoding. Keys are stored in lexicographic
+// order, each sharing as many leading bytes as possible with the key before
+// it. Use UnmarshalCompact to rebuild the tree.
+func (t *Tree[V]) MarshalCompact(encodeVal func(V) []byte) []byte {
+	var values [][]byte
+	var keys []byte
+	index := make(map[string]int)
+	prev, count := "", 0
+
+	walkDescendants(t, func(n *Tree[V]) bool {
+		enc := encodeVal(n.value)
+		ix, ok := index[string(enc)]
+		if !ok {
+			ix = len(values)
+			index[string(enc)] = ix
+			values = append(values, enc)
+		}
+
+		shared := matchingChars(prev, n.key)
+		keys = binary.AppendUvarint(keys, uint64(shared))
+		keys = appendBytes(keys, n.key[shared:])
+		keys = binary.AppendUvarint(keys, uint64(ix))
+		prev = n.key
+		count++
+		return true
+	})
+
+	var data []byte
+	data = binary.AppendUvarint(data, uint64(len(values)))
+	for _, v := range values {
+		data = appendBytes(data, v)
+	}
+	data = binary.AppendUvarint(data, uint64(count))
+	return append(data, keys...)
+}
+
+// UnmarshalCompact rebuilds a prefix tree from data produced by
+// MarshalCompact, using decodeVal to decode each distinct value. Keys sharing
+// an encoded value receive copies of the same decoded value. The tree is
+// created with the provided options, which should match those of the tree
+// that was marshaled, since only its keys and values are recorded. If the
+// data is malformed, ErrInvalidEncoding is returned. If decodeVal fails, its
+// error is returned.
+func UnmarshalCompact[V any](data []byte, decodeVal func([]byte) (V, error), opts ...Option) (*Tree[V], error) {
+	d := decoder{data: data}
+
+	values := make([]V, d.count())
+	for i := range values {
+		enc := d.bytes()
+		if d.err != nil {
+			return nil, d.err
+		}
+		v, err := decodeVal(enc)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	t := New[V](opts...)
+	key := []byte{}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		shared := d.uvarint()
+		if shared > uint64(len(key)) {
+			return nil, ErrInvalidEncoding
+		}
+		key = append(key[:shared], d.bytes()...)
+		ix := d.uvarint()
+		if d.err == nil && ix >= uint64(len(values)) {
+			return nil, ErrInvalidEncoding
+		}
+		if d.err == nil {
+			t.Add(string(key), values[ix])
+		}
+	}
+	if d.err == nil && len(d.data) > 0 {
+		d.err = ErrInvalidEncoding
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return t, nil
+}
+
+// MarshalJSON encodes the keys and values of the prefix tree as a JSON
+// object mapping each key to its value, which is encoded using the standard
+// encoding/json rules for V. The internal structure of the tree isn't
+// encoded.
+func (t *Tree[V]) MarshalJSON() ([]byte, error) {
+	m := make(map[string]V, t.descendants)
+	walkDescendants(t, func(n *Tree[V]) bool {
+		m[n.key] = n.value
+		return true
+	})
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON replaces the contents of the prefix tree with the keys and
+// values of a JSON object produced by MarshalJSON, adding each key to the
+// tree. The tree's configuration is left unchanged.
+func (t *Tree[V]) UnmarshalJSON(data []byte) error {
+	var m map[string]V
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	t.opts.acquire()
+	defer t.opts.release()
+
+	t.reset()
+	for key, value := range m {
+		t.add(key, value)
+	}
+	return nil
+}
+
+// GobEncode encodes the keys and values of the prefix tree for use with the
+// encoding/gob package. Keys are stored in lexicographic order, each sharing
+// as many leading bytes as possible with the key before it, and the values
+// are encoded together as a single gob-encoded slice, so V may be any type
+// gob can encode.
+func (t *Tree[V]) GobEncode() ([]byte, error) {
+	keys := binary.AppendUvarint(nil, uint64(t.descendants))
+	values := make([]V, 0, t.descendants)
+	prev := ""
+	walkDescendants(t, func(n *Tree[V]) bool {
+		shared := matchingChars(prev, n.key)
+		keys = binary.AppendUvarint(keys, uint64(shared))
+		keys = appendBytes(keys, n.key[shared:])
+		values = append(values, n.value)
+		prev = n.key
+		return true
+	})
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(keys); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode replaces the contents of the prefix tree with the keys and
+// values encoded by GobEncode. The tree's configuration is left unchanged.
+// If the keys are malformed, ErrInvalidEncoding is returned.
+func (t *Tree[V]) GobDecode(data []byte) error {
+	var keys []byte
+	var values []V
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&keys); err != nil {
+		return err
+	}
+	if err := dec.Decode(&values); err != nil {
+		return err
+	}
+
+	// Decode all of the keys before modifying the tree, so a malformed
+	// encoding leaves the tree untouched.
+	d := decoder{data: keys}
+	decoded := make([]string, d.count())
+	if d.err == nil && len(decoded) != len(values) {
+		return ErrInvalidEncoding
+	}
+	key := []byte{}
+	for i := 0; i < len(decoded) && d.err == nil; i++ {
+		shared := d.uvarint()
+		if shared > uint64(len(key)) {
+			return ErrInvalidEncoding
+		}
+		key = append(key[:shared], d.bytes()...)
+		decoded[i] = string(key)
+	}
+	if d.err == nil && len(d.data) > 0 {
+		d.err = ErrInvalidEncoding
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	t.opts.acquire()
+	defer t.opts.release()
+
+	t.reset()
+	for i, key := range decoded {
+		t.add(key, values[i])
+	}
+	return nil
+}
+
+// saveMagic identifies data produced by Save, and saveVersion is the version
+// of the format following it. The version must be incremented whenever the
+// format changes.
+const (
+	saveMagic   = "PFXT"
+	saveVersion = 1
+)
+
+// Save serializes the prefix tree into a self-describing blob suitable for
+// embedding in a binary with go:embed, using encodeVal to encode each value.
+// The blob carries a format version, so data saved by one release of the
+// package is detected rather than misread by a later release that changes
+// the format. Use Load to rebuild the tree.
+func (t *Tree[V]) Save(encodeVal func(V) []byte) []byte {
+	data := append([]byte(saveMagic), saveVersion)
+	return append(data, t.MarshalCompact(encodeVal)...)
+}
+
+// Load rebuilds a prefix tree from data produced by Save, using decodeVal to
+// decode each distinct value. As with UnmarshalCompact, the tree is created
+// with the provided options, which should match those of the saved tree. If
+// the data wasn't produced by Save or is malformed, ErrInvalidEncoding is
+// returned. If it was saved in a format version this package doesn't
+// support, ErrUnsupportedVersion is returned. If decodeVal fails, its error
+// is returned.
+func Load[V any](data []byte, decodeVal func([]byte) (V, error), opts ...Option) (*Tree[V], error) {
+	if len(data) <= len(saveMagic) || string(data[:len(saveMagic)]) != saveMagic {
+		return nil, ErrInvalidEncoding
+	}
+	if data[len(saveMagic)] != saveVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	return UnmarshalCompact(data[len(saveMagic)+1:], decodeVal, opts...)
+}
+
+// structureMagic identifies data produced by WriteTo, and structureVersion
+// is the version of the format following it. The version must be incremented
+// whenever the format changes.
+const (
+	structureMagic   = "PFXN"
+	structureVersion = 1
+)
+
+// Flags recorded by WriteTo for the options that determine the structure of
+// a tree.
+const (
+	flagUncompressed = 1 << iota
+	flagCaseFolding
+	flagSplitting
+	flagRuneAligned
+)
+
+// structureFlags returns the flags describing the options that determine
+// the structure of a tree.
+func (o *options) structureFlags() byte {
+	var flags byte
+	if o.isUncompressed() {
+		flags |= flagUncompressed
+	}
+	if o.isCaseFolding() {
+		flags |= flagCaseFolding
+	}
+	if o.isSplitting() {
+		flags |= flagSplitting
+	}
+	if o.isRuneAligned() {
+		flags |= flagRuneAligned
+	}
+	return flags
+}
+
+// WriteTo writes the prefix tree's nodes and links to w exactly as they are
+// arranged in memory, so that ReadFrom can restore the tree without adding
+// its keys one at a time. The values are encoded together as a single
+// gob-encoded slice, so V may be any type gob can encode. The data begins
+// with a format version, so data written by one release of the package is
+// detected rather than misread by a later release that changes the format.
+// WriteTo returns the number of bytes written.
+func (t *Tree[V]) WriteTo(w io.Writer) (int64, error) {
+	var structure []byte
+	values := make([]V, 0, t.descendants)
+	var appendNode func(n *Tree[V])
+	appendNode = func(n *Tree[V]) {
+		structure = appendBytes(structure, n.key)
+		if n.isTerminal() {
+			values = append(values, n.value)
+		}
+		structure = binary.AppendUvarint(structure, uint64(n.descendants))
+		structure = binary.AppendUvarint(structure, uint64(len(n.links)))
+		for i := 0; i < len(n.links); i++ {
+			structure = binary.AppendUvarint(structure, uint64(len(n.links[i].keyseg)))
+			appendNode(n.links[i].tree)
+		}
+	}
+	appendNode(t)
+
+	cw := &countingWriter{w: w}
+	header := append([]byte(structureMagic), structureVersion, t.opts.structureFlags())
+	if _, err := cw.Write(header); err != nil {
+		return cw.n, err
+	}
+	enc := gob.NewEncoder(cw)
+	if err := enc.Encode(structure); err != nil {
+		return cw.n, err
+	}
+	if err := enc.Encode(values); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+// ReadFrom restores a prefix tree written by WriteTo from r, recreating its
+// nodes and links directly rather than adding its keys. Options may be
+// provided to configure the tree, but the options that determine a tree's
+// structure, WithoutCompression, WithCaseFolding, WithSplitter and
+// WithRuneBoundaries, must be the ones the tree was written with; a splitter
+// must also divide keys the same way. The restored structure is checked for
+// consistency, including the per-node key counts, and if it is malformed,
+// truncated or was written with different structural options,
+// ErrInvalidEncoding is returned. If it was written in a format version this
+// package doesn't support, ErrUnsupportedVersion is returned. Other errors
+// reading r or decoding the values are returned as they are.
+func ReadFrom[V any](r io.Reader, opts ...Option) (*Tree[V], error) {
+	t := New[V](opts...)
+
+	header := make([]byte, len(structureMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrInvalidEncoding
+		}
+		return nil, err
+	}
+	switch {
+	case string(header[:len(structureMagic)]) != structureMagic:
+		return nil, ErrInvalidEncoding
+	case header[len(structureMagic)] != structureVersion:
+		return nil, ErrUnsupportedVersion
+	case header[len(structureMagic)+1] != t.opts.structureFlags():
+		return nil, ErrInvalidEncoding
+	}
+
+	var structure []byte
+	var values []V
+	dec := gob.NewDecoder(r)
+	err := dec.Decode(&structure)
+	if err == nil {
+		err = dec.Decode(&values)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrInvalidEncoding
+	} else if err != nil {
+		return nil, err
+	}
+
+	d := decoder{data: structure}
+	root, err := readNode(t, &d, 0)
+	switch {
+	case err != nil:
+		return nil, err
+	case len(d.data) > 0 || root.isTerminal() || root.descendants != len(values):
+		return nil, ErrInvalidEncoding
+	}
+	t.links, t.descendants = root.links, root.descendants
+	i := 0
+	walkDescendants(t, func(n *Tree[V]) bool {
+		n.value = values[i]
+		i++
+		return true
+	})
+	return t, nil
+}
+
+// readNode decodes a node written by WriteTo whose path from the root of the
+// prefix tree t is depth bytes long, along with all of the nodes beneath it.
+// Each link's key segment is cut from a key beneath the link, so it shares
+// that key's memory, and the node is checked for consistency with the nodes
+// beneath it.
+func readNode[V any](t *Tree[V], d *decoder, depth int) (*Tree[V], error) {
+	n := &Tree[V]{key: string(d.bytes())}
+	descendants := d.uvarint()
+	links := d.count()
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	// A key must be spelled by the path leading to it.
+	var path string
+	count := 0
+	if n.isTerminal() {
+		if path = t.opts.fold(n.key); len(path) != depth {
+			return nil, ErrInvalidEncoding
+		}
+		count++
+	}
+
+	if links > 0 {
+		n.links = make([]link[V], links)
+	}
+	for i := range n.links {
+		seglen := d.uvarint()
+		if d.err != nil {
+			return nil, d.err
+		}
+		// Segments aren't written, so their lengths can only be checked
+		// against the keys beneath them once those have been read.
+		if seglen == 0 || seglen > uint64(math.MaxInt-depth) {
+			return nil, ErrInvalidEncoding
+		}
+		child, err := readNode(t, d, depth+int(seglen))
+		if err != nil {
+			return nil, err
+		}
+
+		// Every link must lead to a key, and all of a node's links must
+		// share the path leading to the node and begin with distinct
+		// characters in ascending order. Links hold whole characters, and
+		// only one of them in an uncompressed tree.
+		if child.descendants == 0 {
+			return nil, ErrInvalidEncoding
+		}
+		childPath := t.opts.fold(firstDescendant(child).key)
+		if path == "" {
+			path = childPath[:depth]
+		}
+		if childPath[:depth] != path {
+			return nil, ErrInvalidEncoding
+		}
+		seg := childPath[depth : depth+int(seglen)]
+		switch {
+		case i > 0 && t.opts.firstChar(seg) <= t.opts.firstChar(n.links[i-1].keyseg),
+			t.opts.charBoundary(seg, len(seg)) != len(seg),
+			t.opts.isUncompressed() && len(seg) != t.opts.charLen(seg):
+			return nil, ErrInvalidEncoding
+		}
+		n.links[i] = link[V]{seg, child}
+		count += child.descendants
+	}
+
+	// A compressed tree has no nodes with a single link that don't hold a
+	// key, except the root.
+	if depth > 0 && !n.isTerminal() && links < 2 && !t.opts.isUncompressed() {
+		return nil, ErrInvalidEncoding
+	}
+	if uint64(count) != descendants {
+		return nil, ErrInvalidEncoding
+	}
+	n.descendants = count
+	return n, nil
+}
+
+// A countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// appendBytes appends the length of b followed by the contents of b to data.
+func appendBytes[B []byte | string](data []byte, b B) []byte {
+	data = binary.AppendUvarint(data, uint64(len(b)))
+	return append(data, b...)
+}
+
+// A decoder consumes length-prefixed data produced by the package's
+// serializers. Once an error occurs, all further reads return zero values
+// and the error is retained in err.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+// uvarint consumes an unsigned varint.
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = ErrInvalidEncoding
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count consumes an unsigned varint holding the number of items that follow,
+// rejecting counts that couldn't possibly fit in the remaining data.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.err = ErrInvalidEncoding
+		return 0
+	}
+	return int(n)
+}
+
+// bytes consumes a length-prefixed byte slice.
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.data)) {
+		d.err = ErrInvalidEncoding
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
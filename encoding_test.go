@@ -0,0 +1,383 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func encodeInt(v int) []byte {
+	return []byte(strconv.Itoa(v))
+}
+
+func decodeInt(b []byte) (int, error) {
+	return strconv.Atoi(string(b))
+}
+
+func TestMarshalCompact(t *testing.T) {
+	entries := []entry{
+		{"apple", 1},
+		{"applepie", 2},
+		{"a", 1},
+		{"arm", 2},
+		{"armor", 1},
+		{"bee", 3},
+	}
+
+	tree := New[int]()
+	for _, e := range entries {
+		tree.Add(e.key, e.value)
+	}
+
+	data := tree.MarshalCompact(encodeInt)
+	decoded, err := UnmarshalCompact(data, decodeInt)
+	if err != nil {
+		t.Fatalf("UnmarshalCompact returned error: %v\n", err)
+	}
+
+	for i, kv := range tree.FindKeyValues("") {
+		value, err := decoded.FindValue(kv.Key)
+		if err != nil || value != kv.Value {
+			t.Errorf("Case %d: FindValue(\"%s\") returned %d (%v), expected %d.\n",
+				i, kv.Key, value, err, kv.Value)
+		}
+	}
+	if keys := decoded.FindKeys(""); !slices.Equal(keys, tree.FindKeys("")) {
+		t.Errorf("Decoded tree holds keys %v, expected %v.\n", keys, tree.FindKeys(""))
+	}
+
+	// Each distinct value is encoded only once.
+	if n := bytes.Count(data, []byte("1")); n != 1 {
+		t.Errorf("Value 1 was encoded %d times, expected once.\n", n)
+	}
+
+	// An empty tree round-trips.
+	decoded, err = UnmarshalCompact(New[int]().MarshalCompact(encodeInt), decodeInt)
+	if err != nil || len(decoded.FindKeys("")) != 0 {
+		t.Errorf("Empty tree round trip returned %v (%v).\n", decoded.FindKeys(""), err)
+	}
+
+	// A case-folding tree round-trips when decoded with the same options.
+	folding := New[int](WithCaseFolding())
+	folding.Add("Apple", 1)
+	folding.Add("arm", 2)
+	decoded, err = UnmarshalCompact(folding.MarshalCompact(encodeInt), decodeInt, WithCaseFolding())
+	if err != nil {
+		t.Fatalf("UnmarshalCompact returned error: %v\n", err)
+	}
+	if keys := decoded.FindKeys("A"); !slices.Equal(keys, []string{"Apple", "arm"}) {
+		t.Errorf("Decoded case-folding tree matched %v for \"A\", expected [Apple arm].\n", keys)
+	}
+	if v, err := decoded.FindValue("APPLE"); v != 1 || err != nil {
+		t.Errorf("FindValue(\"APPLE\") returned (%d, %v), expected (1, nil).\n", v, err)
+	}
+}
+
+func TestUnmarshalCompactErrors(t *testing.T) {
+	tree := New[int]()
+	tree.Add("apple", 1)
+	tree.Add("bee", 2)
+	data := tree.MarshalCompact(encodeInt)
+
+	for i := 0; i < len(data); i++ {
+		if _, err := UnmarshalCompact(data[:i], decodeInt); err != ErrInvalidEncoding {
+			t.Errorf("Case %d: truncated data returned error %v, expected %v.\n",
+				i, err, ErrInvalidEncoding)
+		}
+	}
+
+	if _, err := UnmarshalCompact(append(data, 0), decodeInt); err != ErrInvalidEncoding {
+		t.Errorf("Trailing data returned error %v, expected %v.\n", err, ErrInvalidEncoding)
+	}
+
+	errDecode := errors.New("decode failed")
+	_, err := UnmarshalCompact(data, func([]byte) (int, error) { return 0, errDecode })
+	if err != errDecode {
+		t.Errorf("Failed value decode returned error %v, expected %v.\n", err, errDecode)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tree := New[string]()
+	for _, key := range []string{"apple", "applepie", "arm", "bee"} {
+		tree.Add(key, strings.ToUpper(key))
+	}
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatalf("json.Marshal returned error: %v\n", err)
+	}
+	expected := `{"apple":"APPLE","applepie":"APPLEPIE","arm":"ARM","bee":"BEE"}`
+	if string(data) != expected {
+		t.Errorf("json.Marshal returned %s, expected %s.\n", data, expected)
+	}
+
+	for _, opts := range representations {
+		// Unmarshaling replaces any keys already in the tree.
+		decoded := New[string](opts...)
+		decoded.Add("bog", "BOG")
+		if err := json.Unmarshal(data, decoded); err != nil {
+			t.Fatalf("json.Unmarshal returned error: %v\n", err)
+		}
+		if kvs := decoded.FindKeyValues(""); !slices.Equal(kvs, tree.FindKeyValues("")) {
+			t.Errorf("Decoded tree holds %v, expected %v.\n", kvs, tree.FindKeyValues(""))
+		}
+		if value, err := decoded.FindValue("ar"); value != "ARM" || err != nil {
+			t.Errorf("FindValue(\"ar\") on the decoded tree returned (%s, %v).\n", value, err)
+		}
+	}
+
+	// An empty tree round-trips.
+	data, err = json.Marshal(New[string]())
+	if err != nil || string(data) != "{}" {
+		t.Errorf("json.Marshal of an empty tree returned %s (%v), expected {}.\n", data, err)
+	}
+	decoded := New[string]()
+	if err := json.Unmarshal(data, decoded); err != nil || decoded.Len() != 0 {
+		t.Errorf("json.Unmarshal of an empty tree returned %v (%v).\n", decoded.FindKeys(""), err)
+	}
+
+	// Values that don't match the value type are rejected.
+	if err := json.Unmarshal([]byte(`{"apple":1}`), decoded); err == nil {
+		t.Errorf("json.Unmarshal of mistyped values succeeded.\n")
+	}
+}
+
+func TestGob(t *testing.T) {
+	type record struct {
+		Name  string
+		Count int
+	}
+
+	tree := New[record]()
+	for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+		tree.Add(key, record{strings.ToUpper(key), i})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(tree); err != nil {
+		t.Fatalf("gob Encode returned error: %v\n", err)
+	}
+
+	for _, opts := range representations {
+		// Decoding replaces any keys already in the tree.
+		decoded := New[record](opts...)
+		decoded.Add("bog", record{})
+		if err := gob.NewDecoder(bytes.NewReader(buf.Bytes())).Decode(decoded); err != nil {
+			t.Fatalf("gob Decode returned error: %v\n", err)
+		}
+		for _, prefix := range []string{"", "a", "ap", "apple", "applep", "ar", "b", "bo"} {
+			v1, err1 := decoded.FindValue(prefix)
+			v2, err2 := tree.FindValue(prefix)
+			if v1 != v2 || err1 != err2 {
+				t.Errorf("FindValue(\"%s\") on the decoded tree returned (%v, %v), expected (%v, %v).\n",
+					prefix, v1, err1, v2, err2)
+			}
+		}
+		if kvs := decoded.FindKeyValues(""); !slices.Equal(kvs, tree.FindKeyValues("")) {
+			t.Errorf("Decoded tree holds %v, expected %v.\n", kvs, tree.FindKeyValues(""))
+		}
+	}
+
+	// An empty tree round-trips.
+	data, err := New[int]().GobEncode()
+	if err != nil {
+		t.Fatalf("GobEncode of an empty tree returned error: %v\n", err)
+	}
+	decoded := New[int]()
+	decoded.Add("apple", 1)
+	if err := decoded.GobDecode(data); err != nil || decoded.Len() != 0 {
+		t.Errorf("GobDecode of an empty tree returned %v (%v).\n", decoded.FindKeys(""), err)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	tree := New[int]()
+	for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+		tree.Add(key, i)
+	}
+
+	// Simulate a blob embedded in the binary at build time.
+	embedded := tree.Save(encodeInt)
+
+	loaded, err := Load(embedded, decodeInt)
+	if err != nil {
+		t.Fatalf("Load returned error: %v\n", err)
+	}
+	if kvs, expected := loaded.FindKeyValues(""), tree.FindKeyValues(""); !slices.Equal(kvs, expected) {
+		t.Errorf("Loaded tree holds %v, expected %v.\n", kvs, expected)
+	}
+
+	// A case-folding tree is loaded with the options it was saved with.
+	folding := New[int](WithCaseFolding())
+	folding.Add("Apple", 1)
+	folding.Add("arm", 2)
+	loaded, err = Load(folding.Save(encodeInt), decodeInt, WithCaseFolding())
+	if err != nil {
+		t.Fatalf("Load returned error: %v\n", err)
+	}
+	if keys := loaded.FindKeys("A"); !slices.Equal(keys, []string{"Apple", "arm"}) {
+		t.Errorf("Loaded case-folding tree matched %v for \"A\", expected [Apple arm].\n", keys)
+	}
+	if v, err := loaded.FindValue("APPLE"); v != 1 || err != nil {
+		t.Errorf("FindValue(\"APPLE\") returned (%d, %v), expected (1, nil).\n", v, err)
+	}
+
+	cases := []struct {
+		data []byte
+		err  error
+	}{
+		{nil, ErrInvalidEncoding},
+		{[]byte("PFXT"), ErrInvalidEncoding},
+		{tree.MarshalCompact(encodeInt), ErrInvalidEncoding},
+		{append([]byte("PFXT\x02"), embedded[5:]...), ErrUnsupportedVersion},
+		{embedded[:len(embedded)-1], ErrInvalidEncoding},
+	}
+	for i, c := range cases {
+		if _, err := Load(c.data, decodeInt); err != c.err {
+			t.Errorf("Case %d: Load returned error %v, expected %v.\n", i, err, c.err)
+		}
+	}
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "Arm", "armor", "bee", "beetle", "bog"}
+	for _, opts := range [][]Option{nil, {WithoutCompression()}, {WithCaseFolding()}, {WithSplitter(SplitRunes)}} {
+		tree := New[int](opts...)
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+
+		var buf bytes.Buffer
+		n, err := tree.WriteTo(&buf)
+		if err != nil || n != int64(buf.Len()) {
+			t.Fatalf("WriteTo returned (%d, %v), expected (%d, nil).\n", n, err, buf.Len())
+		}
+		data := buf.Bytes()
+
+		loaded, err := ReadFrom[int](bytes.NewReader(data), opts...)
+		if err != nil {
+			t.Fatalf("ReadFrom returned error: %v\n", err)
+		}
+		if loaded.String() != tree.String() {
+			t.Errorf("Loaded tree is\n%s\nexpected\n%s\n", loaded.String(), tree.String())
+		}
+		for _, prefix := range []string{"", "a", "ap", "apple", "applep", "ar", "arm", "b", "be", "bo", "c"} {
+			v1, err1 := loaded.FindValue(prefix)
+			v2, err2 := tree.FindValue(prefix)
+			if v1 != v2 || err1 != err2 {
+				t.Errorf("FindValue(\"%s\") on the loaded tree returned (%v, %v), expected (%v, %v).\n",
+					prefix, v1, err1, v2, err2)
+			}
+		}
+		checkCounts(t, loaded)
+
+		// The loaded tree accepts further changes.
+		loaded.Add("bogus", 99)
+		if value, err := loaded.FindValue("bogu"); value != 99 || err != nil {
+			t.Errorf("FindValue(\"bogu\") after Add returned (%d, %v).\n", value, err)
+		}
+
+		// Damaged data is rejected without panicking.
+		for i := 0; i < len(data); i++ {
+			if _, err := ReadFrom[int](bytes.NewReader(data[:i]), opts...); err == nil {
+				t.Errorf("Case %d: truncated data was accepted.\n", i)
+			}
+			damaged := slices.Clone(data)
+			damaged[i] ^= 0x5a
+			if tree, err := ReadFrom[int](bytes.NewReader(damaged), opts...); err == nil {
+				checkCounts(t, tree)
+			}
+		}
+	}
+
+	// Trees holding a single long key have segments much longer than the
+	// data written for them, especially when keys are divided into tokens.
+	splitDots := func(s string) []string { return strings.Split(s, ".") }
+	for i, opts := range [][]Option{nil, {WithSplitter(SplitBytes)}, {WithSplitter(SplitRunes)}, {WithSplitter(splitDots)}} {
+		for _, key := range []string{"ccca", "abcdef", "a.b.c", "日本語のテキスト"} {
+			tree := New[int](opts...)
+			tree.Add(key, 1)
+			var buf bytes.Buffer
+			if _, err := tree.WriteTo(&buf); err != nil {
+				t.Fatalf("Case %d: WriteTo returned error: %v\n", i, err)
+			}
+			loaded, err := ReadFrom[int](&buf, opts...)
+			if err != nil {
+				t.Errorf("Case %d: ReadFrom of \"%s\" returned error: %v\n", i, key, err)
+				continue
+			}
+			if kv, err := loaded.FindKeyValue(key); kv.Key != key || kv.Value != 1 || err != nil {
+				t.Errorf("Case %d: loaded tree holds (%v, %v), expected \"%s\".\n", i, kv, err, key)
+			}
+		}
+	}
+
+	tree := New[int]()
+	tree.Add("apple", 1)
+	tree.Add("arm", 2)
+	var buf bytes.Buffer
+	tree.WriteTo(&buf)
+	data := buf.Bytes()
+
+	cases := []struct {
+		data []byte
+		opts []Option
+		err  error
+	}{
+		{nil, nil, ErrInvalidEncoding},
+		{[]byte("PFXN"), nil, ErrInvalidEncoding},
+		{append([]byte("PFXT"), data[4:]...), nil, ErrInvalidEncoding},
+		{append([]byte("PFXN\x02"), data[5:]...), nil, ErrUnsupportedVersion},
+		{data, []Option{WithoutCompression()}, ErrInvalidEncoding},
+		{data, []Option{WithCaseFolding()}, ErrInvalidEncoding},
+		{data, []Option{WithSplitter(SplitRunes)}, ErrInvalidEncoding},
+	}
+	for i, c := range cases {
+		if _, err := ReadFrom[int](bytes.NewReader(c.data), c.opts...); err != c.err {
+			t.Errorf("Case %d: ReadFrom returned error %v, expected %v.\n", i, err, c.err)
+		}
+	}
+
+	// An empty tree round-trips.
+	buf.Reset()
+	New[int]().WriteTo(&buf)
+	if loaded, err := ReadFrom[int](&buf); err != nil || loaded.Len() != 0 {
+		t.Errorf("ReadFrom of an empty tree returned %v (%v).\n", loaded, err)
+	}
+}
+
+func BenchmarkMarshalCompact(b *testing.B) {
+	// Build a tree with many keys but only a handful of distinct values, and
+	// report the compact encoding's size alongside the size of a naive
+	// encoding storing every key and value in full.
+	const value = "a reasonably long value shared by many keys"
+	keys := benchmarkKeys(50000)
+	tree := New[string]()
+	for i, key := range keys {
+		tree.Add(key, value[:len(value)-i%4])
+	}
+	encode := func(v string) []byte { return []byte(v) }
+
+	naive := 0
+	for _, kv := range tree.FindKeyValues("") {
+		naive += len(appendBytes(appendBytes(nil, kv.Key), kv.Value))
+	}
+
+	b.ResetTimer()
+	var data []byte
+	for i := 0; i < b.N; i++ {
+		data = tree.MarshalCompact(encode)
+	}
+	b.ReportMetric(float64(len(data)), "compact-bytes")
+	b.ReportMetric(float64(naive), "naive-bytes")
+}
@@ -0,0 +1,54 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import "container/heap"
+
+// A topN collects the n greatest items offered to it, as ordered by a less
+// function. The items are kept in a min-heap, so only n items are retained at
+// any time and the least of them is cheap to replace.
+type topN[T any] struct {
+	n     int
+	less  func(a, b T) bool
+	items []T
+}
+
+// newTopN returns a collection retaining the n greatest items according to
+// less.
+func newTopN[T any](n int, less func(a, b T) bool) *topN[T] {
+	return &topN[T]{n: n, less: less}
+}
+
+func (h *topN[T]) Len() int           { return len(h.items) }
+func (h *topN[T]) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *topN[T]) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *topN[T]) Push(x any)         { h.items = append(h.items, x.(T)) }
+
+func (h *topN[T]) Pop() any {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
+
+// add offers an item to the collection, which keeps it only if it is among
+// the n greatest items offered so far.
+func (h *topN[T]) add(x T) {
+	switch {
+	case len(h.items) < h.n:
+		heap.Push(h, x)
+	case len(h.items) > 0 && h.less(h.items[0], x):
+		h.items[0] = x
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted empties the collection, returning its items greatest first.
+func (h *topN[T]) sorted() []T {
+	items := make([]T, len(h.items))
+	for i := len(items) - 1; i >= 0; i-- {
+		items[i] = heap.Pop(h).(T)
+	}
+	return items
+}
@@ -0,0 +1,105 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import "slices"
+
+// An Inverted index maps tokens derived from the values stored in a prefix
+// tree back to the keys holding those values. The tokens are themselves kept
+// in a prefix tree, so they can be searched by prefix as well as exactly.
+//
+// The index reflects the tree as of its creation or last Rebuild. Keys added
+// through the index's Add method keep the index and the tree in sync
+// incrementally; changes made directly to the tree are only picked up by the
+// next call to Rebuild.
+type Inverted[V any] struct {
+	tree     *Tree[V]
+	tokenize func(V) []string
+	tokens   *Tree[[]string]
+}
+
+// NewInverted builds an inverted index over the values of tree, using
+// tokenize to derive the tokens of each value.
+func NewInverted[V any](tree *Tree[V], tokenize func(V) []string) *Inverted[V] {
+	idx := &Inverted[V]{tree: tree, tokenize: tokenize}
+	idx.Rebuild()
+	return idx
+}
+
+// Rebuild discards the index and rebuilds it from the current contents of
+// the tree.
+func (idx *Inverted[V]) Rebuild() {
+	idx.tokens = New[[]string]()
+	walkDescendants(idx.tree, func(n *Tree[V]) bool {
+		idx.insert(n.key, n.value)
+		return true
+	})
+}
+
+// Add adds a key and its associated value to the indexed tree, updating the
+// index to match. If the key was already in the tree, the tokens of its old
+// value no longer refer to it. The index refers to keys in the form the tree
+// stores them, which in a case-folding tree may differ from the key added.
+// As with Tree.Add, the empty key is ignored.
+func (idx *Inverted[V]) Add(key string, value V) {
+	if n := idx.tree.findExact(key); n != nil {
+		idx.remove(n.key, n.value)
+	}
+
+	idx.tree.opts.acquire()
+	n, _ := idx.tree.insert(key, value, true)
+	idx.tree.opts.release()
+	if n != nil {
+		idx.insert(n.key, value)
+	}
+}
+
+// Find returns the keys whose values produced the token, in lexicographic
+// order.
+func (idx *Inverted[V]) Find(token string) []string {
+	if n := idx.tokens.findExact(token); n != nil {
+		return slices.Clone(n.value)
+	}
+	return []string{}
+}
+
+// FindPrefix returns the keys whose values produced any token starting with
+// the provided prefix, in lexicographic order and without duplicates.
+func (idx *Inverted[V]) FindPrefix(prefix string) []string {
+	keys := []string{}
+	if st, _ := idx.tokens.prefixSubtree(prefix); st != nil {
+		walkDescendants(st, func(n *Tree[[]string]) bool {
+			keys = append(keys, n.value...)
+			return true
+		})
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// remove forgets that key's value produced each of value's tokens.
+func (idx *Inverted[V]) remove(key string, value V) {
+	for _, token := range idx.tokenize(value) {
+		if n := idx.tokens.findExact(token); n != nil {
+			if i, found := slices.BinarySearch(n.value, key); found {
+				n.value = slices.Delete(n.value, i, i+1)
+			}
+		}
+	}
+}
+
+// insert records that key's value produced each of value's tokens.
+func (idx *Inverted[V]) insert(key string, value V) {
+	for _, token := range idx.tokenize(value) {
+		n := idx.tokens.findExact(token)
+		if n == nil {
+			idx.tokens.Add(token, []string{key})
+			continue
+		}
+		if i, found := slices.BinarySearch(n.value, key); !found {
+			n.value = slices.Insert(n.value, i, key)
+		}
+	}
+}
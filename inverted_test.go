@@ -0,0 +1,92 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInverted(t *testing.T) {
+	tree := New[string]()
+	tree.Add("apple", "red fruit")
+	tree.Add("banana", "yellow fruit")
+	tree.Add("cherry", "red red stone fruit")
+	tree.Add("lemon", "yellow sour")
+
+	idx := NewInverted(tree, strings.Fields)
+
+	cases := []struct {
+		token string
+		keys  []string
+	}{
+		{"red", []string{"apple", "cherry"}},
+		{"fruit", []string{"apple", "banana", "cherry"}},
+		{"yellow", []string{"banana", "lemon"}},
+		{"stone", []string{"cherry"}},
+		{"re", []string{}},
+		{"green", []string{}},
+	}
+	for i, c := range cases {
+		if keys := idx.Find(c.token); !slices.Equal(keys, c.keys) {
+			t.Errorf("Case %d: Find(\"%s\") returned %v, expected %v.\n",
+				i, c.token, keys, c.keys)
+		}
+	}
+
+	if keys := idx.FindPrefix("s"); !slices.Equal(keys, []string{"cherry", "lemon"}) {
+		t.Errorf("FindPrefix(\"s\") returned %v, expected [cherry lemon].\n", keys)
+	}
+
+	// Adding through the index keeps it in sync.
+	idx.Add("apple", "green fruit")
+	idx.Add("lime", "green sour")
+	if keys := idx.Find("red"); !slices.Equal(keys, []string{"cherry"}) {
+		t.Errorf("Find(\"red\") returned %v after Add, expected [cherry].\n", keys)
+	}
+	if keys := idx.Find("green"); !slices.Equal(keys, []string{"apple", "lime"}) {
+		t.Errorf("Find(\"green\") returned %v after Add, expected [apple lime].\n", keys)
+	}
+	if value, err := tree.FindValue("lime"); err != nil || value != "green sour" {
+		t.Errorf("FindValue(\"lime\") returned %q (%v) after Add.\n", value, err)
+	}
+
+	// Adding directly to the tree requires a rebuild.
+	tree.Add("plum", "purple stone fruit")
+	if keys := idx.Find("purple"); len(keys) != 0 {
+		t.Errorf("Find(\"purple\") returned %v before Rebuild.\n", keys)
+	}
+	idx.Rebuild()
+	if keys := idx.Find("stone"); !slices.Equal(keys, []string{"cherry", "plum"}) {
+		t.Errorf("Find(\"stone\") returned %v after Rebuild, expected [cherry plum].\n", keys)
+	}
+}
+
+func TestInvertedCaseFolding(t *testing.T) {
+	for _, casing := range []CasingPolicy{LastAdded, FirstAdded} {
+		tree := New[string](WithCaseFolding(), WithCanonicalCasing(casing))
+		idx := NewInverted(tree, strings.Fields)
+		idx.Add("Apple", "red fruit")
+		idx.Add("APPLE", "green fruit")
+		idx.Add("", "empty")
+
+		// Re-adding the key in another casing drops the old value's tokens,
+		// and the index refers to the key as the tree stores it.
+		key, _ := tree.FindKey("apple")
+		if keys := idx.Find("red"); len(keys) != 0 {
+			t.Errorf("Find(\"red\") returned %v after re-adding, expected [].\n", keys)
+		}
+		if keys := idx.Find("green"); !slices.Equal(keys, []string{key}) {
+			t.Errorf("Find(\"green\") returned %v, expected [%s].\n", keys, key)
+		}
+		if keys := idx.FindPrefix(""); !slices.Equal(keys, []string{key}) {
+			t.Errorf("FindPrefix(\"\") returned %v, expected [%s].\n", keys, key)
+		}
+		if expected := map[CasingPolicy]string{LastAdded: "APPLE", FirstAdded: "Apple"}[casing]; key != expected {
+			t.Errorf("The tree stores %s, expected %s.\n", key, expected)
+		}
+	}
+}
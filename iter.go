@@ -0,0 +1,57 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import "iter"
+
+// All returns an iterator over every key and value stored in the prefix
+// tree, in lexicographic key order. Unlike FindKeyValues, the iterator
+// doesn't collect the keys into a slice, and the walk of the tree stops as
+// soon as the loop ranging over it ends.
+func (t *Tree[V]) All() iter.Seq2[string, V] {
+	return t.Prefixed("")
+}
+
+// Prefixed returns an iterator over every key starting with the provided
+// prefix and its associated value, in lexicographic key order. A stored key
+// exactly matching the prefix is yielded along with all longer keys starting
+// with it. The walk of the tree stops as soon as the loop ranging over the
+// iterator ends.
+func (t *Tree[V]) Prefixed(prefix string) iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		st, _ := t.prefixSubtree(prefix)
+		if st == nil {
+			return
+		}
+		walkDescendants(st, func(n *Tree[V]) bool {
+			return yield(n.key, n.value)
+		})
+	}
+}
+
+// Walk calls fn for every key stored in the prefix tree and its associated
+// value, in lexicographic key order. If fn returns an error, the walk stops
+// and Walk returns the error.
+func (t *Tree[V]) Walk(fn func(key string, value V) error) error {
+	return t.WalkPrefix("", fn)
+}
+
+// WalkPrefix calls fn for every key starting with the provided prefix and
+// its associated value, in lexicographic key order. A stored key exactly
+// matching the prefix is visited along with all longer keys starting with
+// it. If fn returns an error, the walk stops and WalkPrefix returns the
+// error.
+func (t *Tree[V]) WalkPrefix(prefix string, fn func(key string, value V) error) error {
+	st, _ := t.prefixSubtree(prefix)
+	if st == nil {
+		return nil
+	}
+	var err error
+	walkDescendants(st, func(n *Tree[V]) bool {
+		err = fn(n.key, n.value)
+		return err == nil
+	})
+	return err
+}
@@ -0,0 +1,131 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	keys := []string{"apple", "applepie", "arm", "bee", "bog"}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for _, i := range []int{3, 0, 4, 1, 2} {
+			tree.Add(keys[i], i)
+		}
+
+		var got []string
+		for key, value := range tree.All() {
+			if keys[value] != key {
+				t.Errorf("All() yielded (\"%s\", %d), expected value %d.\n",
+					key, value, slices.Index(keys, key))
+			}
+			got = append(got, key)
+		}
+		if !slices.Equal(got, keys) {
+			t.Errorf("All() yielded %v, expected %v.\n", got, keys)
+		}
+
+		// Breaking out of the loop stops the walk.
+		got = nil
+		for key := range tree.All() {
+			got = append(got, key)
+			if len(got) == 2 {
+				break
+			}
+		}
+		if !slices.Equal(got, keys[:2]) {
+			t.Errorf("All() with break yielded %v, expected %v.\n", got, keys[:2])
+		}
+	}
+}
+
+func TestPrefixed(t *testing.T) {
+	cases := []struct {
+		prefix string
+		keys   []string
+	}{
+		{"", []string{"apple", "applepie", "arm", "bee", "bog"}},
+		{"a", []string{"apple", "applepie", "arm"}},
+		{"app", []string{"apple", "applepie"}},
+		{"apple", []string{"apple", "applepie"}},
+		{"applep", []string{"applepie"}},
+		{"applepies", nil},
+		{"b", []string{"bee", "bog"}},
+		{"c", nil},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			var keys []string
+			for key := range tree.Prefixed(c.prefix) {
+				keys = append(keys, key)
+			}
+			if !slices.Equal(keys, c.keys) {
+				t.Errorf("Case %d: Prefixed(\"%s\") yielded %v, expected %v.\n",
+					i, c.prefix, keys, c.keys)
+			}
+		}
+	}
+
+	for key := range New[int]().All() {
+		t.Errorf("All() on an empty tree yielded \"%s\".\n", key)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	errStop := errors.New("stop")
+	cases := []struct {
+		prefix string
+		stopAt string
+		keys   []string
+		err    error
+	}{
+		{"", "", []string{"apple", "applepie", "arm", "bee", "bog"}, nil},
+		{"", "arm", []string{"apple", "applepie", "arm"}, errStop},
+		{"a", "", []string{"apple", "applepie", "arm"}, nil},
+		{"apple", "", []string{"apple", "applepie"}, nil},
+		{"apple", "apple", []string{"apple"}, errStop},
+		{"b", "bog", []string{"bee", "bog"}, errStop},
+		{"c", "", nil, nil},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			var keys []string
+			err := tree.WalkPrefix(c.prefix, func(key string, value int) error {
+				keys = append(keys, key)
+				if key == c.stopAt {
+					return errStop
+				}
+				return nil
+			})
+			if !slices.Equal(keys, c.keys) || err != c.err {
+				t.Errorf("Case %d: WalkPrefix(\"%s\") visited %v and returned %v, expected %v and %v.\n",
+					i, c.prefix, keys, err, c.keys, c.err)
+			}
+		}
+
+		sum := 0
+		err := tree.Walk(func(key string, value int) error {
+			sum += value
+			return nil
+		})
+		if sum != 10 || err != nil {
+			t.Errorf("Walk summed values to %d and returned %v, expected 10 and nil.\n", sum, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"bufio"
+	"io"
+)
+
+// ReadLines builds a prefix tree from newline-separated keys read from r,
+// such as a word list or a dictionary file. Each key is stored with its own
+// text as its value. Empty lines are skipped. Options may be provided to
+// configure the tree. If reading from r fails, the error is returned.
+func ReadLines(r io.Reader, opts ...Option) (*Tree[string], error) {
+	return ReadLinesFunc(r, func(line string) (string, string, error) {
+		return line, line, nil
+	}, opts...)
+}
+
+// ReadLinesFunc builds a prefix tree from lines read from r, using parse to
+// split each line into a key and its value, as for lines of CSV-style
+// records. Empty lines are skipped. Options may be provided to configure the
+// tree. If reading from r fails or parse returns an error, the error is
+// returned.
+func ReadLinesFunc[V any](r io.Reader, parse func(line string) (string, V, error), opts ...Option) (*Tree[V], error) {
+	t := New[V](opts...)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		key, value, err := parse(line)
+		if err != nil {
+			return nil, err
+		}
+		t.add(key, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadLines(t *testing.T) {
+	input := "apple\napplepie\r\n\narm\nbee"
+	tree, err := ReadLines(strings.NewReader(input), WithoutCompression())
+	if err != nil {
+		t.Fatalf("ReadLines returned error: %v\n", err)
+	}
+	expected := []KeyValue[string]{
+		{"apple", "apple"}, {"applepie", "applepie"}, {"arm", "arm"}, {"bee", "bee"},
+	}
+	if kvs := tree.FindKeyValues(""); !slices.Equal(kvs, expected) {
+		t.Errorf("ReadLines built a tree holding %v, expected %v.\n", kvs, expected)
+	}
+	if !tree.opts.isUncompressed() {
+		t.Errorf("ReadLines ignored the tree options.\n")
+	}
+}
+
+func TestReadLinesFunc(t *testing.T) {
+	parse := func(line string) (string, int, error) {
+		key, count, ok := strings.Cut(line, ",")
+		if !ok {
+			return "", 0, errors.New("missing count")
+		}
+		n, err := strconv.Atoi(count)
+		return key, n, err
+	}
+
+	tree, err := ReadLinesFunc(strings.NewReader("apple,3\nbee,1\n\narm,2\n"), parse)
+	if err != nil {
+		t.Fatalf("ReadLinesFunc returned error: %v\n", err)
+	}
+	expected := []KeyValue[int]{{"apple", 3}, {"arm", 2}, {"bee", 1}}
+	if kvs := tree.FindKeyValues(""); !slices.Equal(kvs, expected) {
+		t.Errorf("ReadLinesFunc built a tree holding %v, expected %v.\n", kvs, expected)
+	}
+
+	if _, err := ReadLinesFunc(strings.NewReader("apple,3\nbee\n"), parse); err == nil {
+		t.Errorf("ReadLinesFunc succeeded on a malformed line.\n")
+	}
+}
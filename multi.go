@@ -0,0 +1,56 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import "slices"
+
+// A MultiTree is a prefix tree that stores any number of values with each
+// key, making it a multimap searchable by prefix, such as an index from terms
+// to the documents containing them. Adding a value for a key already stored
+// appends to the key's values rather than replacing them.
+type MultiTree[V any] struct {
+	tree *Tree[[]V]
+}
+
+// NewMulti returns an empty multimap prefix tree with a value type of V.
+// Options may be provided to configure the underlying tree.
+func NewMulti[V any](opts ...Option) *MultiTree[V] {
+	return &MultiTree[V]{tree: New[[]V](opts...)}
+}
+
+// Add appends a value to the values associated with a key string, adding
+// the key to the prefix tree if it isn't already stored. The tree is only
+// descended once. As with Tree.Add, the empty key is ignored.
+func (m *MultiTree[V]) Add(key string, value V) {
+	m.tree.opts.acquire()
+	defer m.tree.opts.release()
+
+	if n, _ := m.tree.insert(key, nil, false); n != nil {
+		n.value = append(n.value, value)
+	}
+}
+
+// FindAll searches the prefix tree for a key string that uniquely matches the
+// prefix. If found, all values associated with the key are returned in the
+// order they were added. If not found, ErrPrefixNotFound is returned. If the
+// prefix matches more than one key in the tree, ErrPrefixAmbiguous is
+// returned. The returned slice is shared with the tree and must not be
+// modified, but values may be appended to it without affecting the tree.
+func (m *MultiTree[V]) FindAll(prefix string) ([]V, error) {
+	values, err := m.tree.FindValue(prefix)
+	return slices.Clip(values), err
+}
+
+// Delete removes a key and all of its associated values from the prefix
+// tree, returning false if the key isn't stored in it.
+func (m *MultiTree[V]) Delete(key string) bool {
+	return m.tree.Delete(key)
+}
+
+// Len returns the number of keys stored in the prefix tree. Each key counts
+// once, however many values are associated with it.
+func (m *MultiTree[V]) Len() int {
+	return m.tree.Len()
+}
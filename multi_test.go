@@ -0,0 +1,58 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMultiTree(t *testing.T) {
+	cases := []struct {
+		prefix string
+		values []int
+		err    error
+	}{
+		{"a", nil, ErrPrefixAmbiguous},
+		{"apple", []int{1, 4, 1}, nil},
+		{"applep", []int{2}, nil},
+		{"ar", []int{3}, nil},
+		{"b", nil, ErrPrefixNotFound},
+	}
+
+	for _, opts := range representations {
+		m := NewMulti[int](opts...)
+		m.Add("apple", 1)
+		m.Add("applepie", 2)
+		m.Add("arm", 3)
+		m.Add("apple", 4)
+		m.Add("apple", 1)
+
+		for i, c := range cases {
+			if values, err := m.FindAll(c.prefix); !slices.Equal(values, c.values) || err != c.err {
+				t.Errorf("Case %d: FindAll(\"%s\") returned (%v, %v), expected (%v, %v).\n",
+					i, c.prefix, values, err, c.values, c.err)
+			}
+		}
+		if n := m.Len(); n != 3 {
+			t.Errorf("Len() returned %d, expected 3.\n", n)
+		}
+
+		// Appending to the values returned leaves the tree unchanged.
+		values, _ := m.FindAll("apple")
+		_ = append(values, 100)
+		m.Add("apple", 5)
+		if values, _ := m.FindAll("apple"); !slices.Equal(values, []int{1, 4, 1, 5}) {
+			t.Errorf("FindAll(\"apple\") returned %v, expected [1 4 1 5].\n", values)
+		}
+
+		if !m.Delete("apple") || m.Delete("apple") {
+			t.Errorf("Delete(\"apple\") failed.\n")
+		}
+		if values, err := m.FindAll("app"); !slices.Equal(values, []int{2}) || err != nil {
+			t.Errorf("FindAll(\"app\") after Delete returned (%v, %v), expected ([2], nil).\n", values, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import "iter"
+
+// A Node is a read-only view of one node of a prefix tree, for use by
+// traversals the tree's own methods don't provide, such as custom searches,
+// exporters and visualizations. A node holds a key if some key ends at it,
+// and has a child for each link leaving it, labeled by the key segment the
+// link spells. The segments along the path from the root to a node holding a
+// key spell the key, or in a tree matching keys in a transformed form, such
+// as a case-folding tree, they spell the key's transformed form. A Node must
+// not be used after its tree is modified.
+type Node[V any] Tree[V]
+
+// Root returns the root node of the prefix tree.
+func (t *Tree[V]) Root() *Node[V] {
+	return (*Node[V])(t)
+}
+
+// Key returns the key stored at the node, or false if no key ends at it.
+func (n *Node[V]) Key() (string, bool) {
+	return n.key, n.key != ""
+}
+
+// Value returns the value associated with the key stored at the node, or the
+// zero value if no key ends at it.
+func (n *Node[V]) Value() V {
+	return n.value
+}
+
+// Len returns the number of keys stored at or beneath the node.
+func (n *Node[V]) Len() int {
+	return n.descendants
+}
+
+// Children returns an iterator over the node's children, yielding the key
+// segment labeling the link to each child along with the child, in
+// lexicographic order of the segments.
+func (n *Node[V]) Children() iter.Seq2[string, *Node[V]] {
+	return func(yield func(string, *Node[V]) bool) {
+		for i := 0; i < len(n.links); i++ {
+			if !yield(n.links[i].keyseg, (*Node[V])(n.links[i].tree)) {
+				return
+			}
+		}
+	}
+}
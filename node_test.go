@@ -0,0 +1,57 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNode(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "arm", "bee"}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+
+		// Rebuild every key from the segments along the path to it.
+		var found []string
+		var walk func(n *Node[int], path string)
+		walk = func(n *Node[int], path string) {
+			if key, ok := n.Key(); ok {
+				if key != path {
+					t.Errorf("Node reached by path %q holds key %q.\n", path, key)
+				}
+				if v := n.Value(); v != slices.Index(keys, key) {
+					t.Errorf("Node holding %q has value %d.\n", key, v)
+				}
+				found = append(found, key)
+			}
+			for seg, child := range n.Children() {
+				walk(child, path+seg)
+			}
+		}
+		root := tree.Root()
+		walk(root, "")
+		if !slices.Equal(found, keys) {
+			t.Errorf("Traversal found keys %v, expected %v.\n", found, keys)
+		}
+		if _, ok := root.Key(); ok || root.Len() != len(keys) {
+			t.Errorf("Root node holds a key or counts %d keys.\n", root.Len())
+		}
+
+		// Iteration over the children stops when the loop ends.
+		count := 0
+		for range root.Children() {
+			count++
+			break
+		}
+		if count != 1 {
+			t.Errorf("Children yielded %d children after the loop ended.\n", count)
+		}
+	}
+}
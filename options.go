@@ -0,0 +1,664 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// An Option configures a prefix tree when it is created by New.
+type Option func(o *options)
+
+// options holds the configuration of a prefix tree. Only the root node of a
+// tree carries options; a nil options pointer selects the default
+// configuration.
+type options struct {
+	uncompressed       bool
+	runeAligned        bool
+	caseFolding        bool
+	casing             CasingPolicy
+	split              func(s string) []string
+	checkConcurrency   bool
+	inUse              atomic.Bool
+	terminalMode       TerminalPrefixMode
+	slowQueryThreshold time.Duration
+	slowQueryHandler   func(prefix string, d time.Duration, results int)
+	metrics            *queryCounters
+	valueSizer         any // func(V) int64 for the tree's value type V
+	linearCutoff       int // 0 selects defaultLinearSearchCutoff
+}
+
+// defaultLinearSearchCutoff is the number of links from a node at which the
+// Find methods switch from a linear to a binary search of the links.
+const defaultLinearSearchCutoff = 20
+
+// WithoutCompression returns an option that disables radix compression.
+// Instead of splitting links on partial matches, an uncompressed tree stores
+// one byte of key per node, so Add never has to split an existing link. In
+// exchange, lookups must follow one link per byte of prefix, and every byte
+// of key not shared with another key costs a node. Uncompressed trees are
+// therefore only competitive for insert-heavy workloads whose keys share most
+// of their bytes; the BenchmarkAdd and BenchmarkFind benchmarks compare the
+// two representations. The representation is chosen when the tree is created
+// and cannot be changed afterward, so compressed and uncompressed nodes are
+// never mixed within one tree.
+func WithoutCompression() Option {
+	return func(o *options) {
+		o.uncompressed = true
+	}
+}
+
+// WithRuneBoundaries returns an option that makes the tree divide keys and
+// match prefixes only between whole UTF-8 characters, so that no link holds
+// part of a character and a prefix ending partway through one matches no
+// keys. Bytes that aren't part of a valid UTF-8 sequence count as characters.
+func WithRuneBoundaries() Option {
+	return func(o *options) {
+		o.runeAligned = true
+	}
+}
+
+// WithCaseFolding returns an option that makes the tree match keys and
+// prefixes case-insensitively, while still returning keys exactly as they
+// were added. Matching uses Unicode simple case folding, the same
+// equivalence used by strings.EqualFold, so "app" finds "Apple" and the
+// Kelvin sign matches "k". No language-specific rules are applied: in
+// particular, the Turkish dotted capital İ and dotless ı each match only
+// themselves, and never "i" or "I". Keys differing only in case are the same
+// key, so adding "apple" to a tree holding "Apple" replaces its value, and
+// the tree holds and returns a single form of the key: by default the
+// casing most recently added, "apple", or the first casing added, "Apple",
+// with WithCanonicalCasing(FirstAdded). Keys are ordered by their folded
+// forms, which for letters is the order of their lowercase forms.
+func WithCaseFolding() Option {
+	return func(o *options) {
+		o.caseFolding = true
+	}
+}
+
+// A CasingPolicy determines which of several keys matching the same stored
+// key, such as "Apple" and "apple" in a case-folding tree, a prefix tree
+// keeps as the key's canonical form, returned by the Find methods.
+type CasingPolicy int
+
+const (
+	// LastAdded keeps the form of the key most recently added. This is the
+	// default policy.
+	LastAdded CasingPolicy = iota
+
+	// FirstAdded keeps the form of the key first added, until the key is
+	// deleted.
+	FirstAdded
+)
+
+// WithCanonicalCasing returns an option that sets the policy used to choose
+// the canonical form of a key when keys differing only in their folded
+// characters are added to a case-folding tree, or keys dividing into the
+// same tokens are added to a tree with a splitter. Either way, the key's
+// value is replaced by the value most recently added.
+func WithCanonicalCasing(policy CasingPolicy) Option {
+	return func(o *options) {
+		o.casing = policy
+	}
+}
+
+// WithSplitter returns an option that makes the tree match keys and
+// prefixes token by token rather than byte by byte, using split to divide
+// each key or prefix into its tokens. A prefix matches the keys whose tokens
+// begin with the prefix's tokens, so with a splitter dividing dotted paths
+// at each dot, the prefix "a.b" matches "a.b" and "a.b.c" but never "a.bc".
+// The last token of a prefix is always treated as complete. The empty
+// prefix matches every key, whatever tokens split returns for it, and split
+// must return at least one token for any other string. Keys are
+// ordered by their token sequences, a token ordering before any longer token
+// it begins. SplitBytes and SplitRunes divide keys into bytes or characters,
+// under which prefixes match as they do without a splitter.
+func WithSplitter(split func(s string) []string) Option {
+	return func(o *options) {
+		o.split = split
+	}
+}
+
+// SplitBytes divides a string into tokens of one byte each. It is a splitter
+// for use with WithSplitter.
+func SplitBytes(s string) []string {
+	tokens := make([]string, len(s))
+	for i := range tokens {
+		tokens[i] = s[i : i+1]
+	}
+	return tokens
+}
+
+// SplitRunes divides a string into tokens of one UTF-8 encoded character
+// each. Bytes not forming valid UTF-8 become tokens of their own. It is a
+// splitter for use with WithSplitter.
+func SplitRunes(s string) []string {
+	tokens := make([]string, 0, len(s))
+	for len(s) > 0 {
+		_, n := utf8.DecodeRuneInString(s)
+		tokens, s = append(tokens, s[:n]), s[n:]
+	}
+	return tokens
+}
+
+// WithValueSizer returns an option that makes EstimatedSize include the
+// memory used by the tree's values, as reported by size for each value. Only
+// memory beyond the value itself, such as the contents of strings, slices and
+// maps the value refers to, should be reported, since EstimatedSize already
+// counts the space the value occupies within the tree's nodes. The option
+// has no effect on a tree whose value type isn't V.
+func WithValueSizer[V any](size func(v V) int64) Option {
+	return func(o *options) {
+		o.valueSizer = size
+	}
+}
+
+// WithLinearSearchCutoff returns an option that sets the number of links
+// leaving a node at which the Find methods switch from checking each link in
+// turn to a binary search of the links. The default cutoff of 20 suits keys
+// drawn from a natural language's alphabet, where most nodes have only a few
+// links. Trees whose nodes have many links, such as trees of keys drawn from
+// a large alphabet of bytes, may search faster with a different cutoff; the
+// BenchmarkLinearSearchCutoff benchmark measures the effect. A cutoff of 1
+// or less makes every search binary.
+func WithLinearSearchCutoff(n int) Option {
+	return func(o *options) {
+		o.linearCutoff = max(n, 1)
+	}
+}
+
+// WithConcurrencyCheck returns an option that makes the tree detect
+// concurrent mutation. A Tree is not safe for concurrent use, and mutating it
+// from two goroutines at once can silently corrupt it. With this option, a
+// mutating method that starts while another is still running panics with a
+// clear message instead. The check costs an atomic operation per mutation,
+// so it is intended for use during development.
+func WithConcurrencyCheck() Option {
+	return func(o *options) {
+		o.checkConcurrency = true
+	}
+}
+
+// isUncompressed returns true if the options select an uncompressed tree.
+func (o *options) isUncompressed() bool {
+	return o != nil && o.uncompressed
+}
+
+// isRuneAligned returns true if the options select matching on rune
+// boundaries.
+func (o *options) isRuneAligned() bool {
+	return o != nil && o.runeAligned
+}
+
+// charLen returns the length in bytes of the first character of the
+// non-empty string s. Characters are single bytes unless the options select
+// matching on rune boundaries, in which case they are whole UTF-8 sequences,
+// and each byte that isn't part of a valid sequence is a character by
+// itself.
+func (o *options) charLen(s string) int {
+	if !o.isRuneAligned() {
+		return 1
+	}
+	_, n := utf8.DecodeRuneInString(s)
+	return n
+}
+
+// firstChar returns the first character of the non-empty string s.
+func (o *options) firstChar(s string) string {
+	return s[:o.charLen(s)]
+}
+
+// charBoundary returns the greatest index of s, no greater than i, at which
+// a character of s begins, so that s may be divided there without dividing
+// a character.
+func (o *options) charBoundary(s string, i int) int {
+	if !o.isRuneAligned() {
+		return i
+	}
+	return runeBoundary(s, i)
+}
+
+// runeBoundary returns the greatest index of s, no greater than i, at which
+// a UTF-8 sequence of s begins. Each byte that isn't part of a valid
+// sequence is a sequence by itself.
+func runeBoundary(s string, i int) int {
+	b := 0
+	for b < i {
+		_, n := utf8.DecodeRuneInString(s[b:])
+		if b+n > i {
+			break
+		}
+		b += n
+	}
+	return b
+}
+
+// keyBoundary returns the greatest length, no greater than n, of a prefix of
+// key that ends between two of the key's characters, or between two of its
+// tokens if the options include a splitter.
+func (o *options) keyBoundary(key string, n int) int {
+	if !o.isSplitting() {
+		return runeBoundary(key, n)
+	}
+	path := o.fold(key)
+	for ; n > 0; n-- {
+		if strings.HasPrefix(path, o.fold(key[:n])) {
+			break
+		}
+	}
+	return n
+}
+
+// splitsRune returns true if the options select matching on rune boundaries
+// and s ends partway through a multibyte UTF-8 sequence.
+func (o *options) splitsRune(s string) bool {
+	if !o.isRuneAligned() {
+		return false
+	}
+	for i := len(s) - 1; i >= 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			return !utf8.FullRuneInString(s[i:])
+		}
+	}
+	return false
+}
+
+// isCaseFolding returns true if the options select case-insensitive
+// matching.
+func (o *options) isCaseFolding() bool {
+	return o != nil && o.caseFolding
+}
+
+// keepsFirstCasing returns true if a stored key keeps the form it was first
+// added with when a key matching it is added again.
+func (o *options) keepsFirstCasing() bool {
+	return o != nil && o.casing == FirstAdded
+}
+
+// fold returns the form of s used to match it against the tree's keys. If
+// the options select case folding, every rune of s is replaced by its
+// case-folded form, and if the options include a splitter, s is divided into
+// tokens that are each followed by a zero byte. Otherwise fold returns s
+// itself.
+func (o *options) fold(s string) string {
+	if !o.isSplitting() || s == "" {
+		return o.foldCase(s)
+	}
+
+	// Zero and one bytes within a token are escaped as two-byte sequences
+	// beginning with a one byte, so that a zero byte always ends a token and
+	// tokens keep their relative order.
+	var b strings.Builder
+	b.Grow(len(s) * 2)
+	for _, token := range o.split(s) {
+		token = o.foldCase(token)
+		for i := 0; i < len(token); i++ {
+			switch c := token[i]; c {
+			case 0, 1:
+				b.WriteByte(1)
+				b.WriteByte(c + 1)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// decodeByte interprets c, the next byte of a folded path in a tree with a
+// splitter, where escaped is true if the byte before c began an escape
+// sequence. It returns the byte of the key's tokens that c stands for and
+// true, or false if c ends a token or begins an escape sequence, in which
+// case escape reports which.
+func decodeByte(c byte, escaped bool) (b byte, ok, escape bool) {
+	switch {
+	case escaped:
+		return c - 1, true, false
+	case c == 0:
+		return 0, false, false
+	case c == 1:
+		return 0, false, true
+	}
+	return c, true, false
+}
+
+// decodeRune returns the first character of the keys' tokens encoded in the
+// folded path s at or after index i, along with the index just past the
+// character's encoding, or false if no character follows i. Index i must not
+// lie within an escape sequence.
+func (o *options) decodeRune(s string, i int) (r rune, end int, ok bool) {
+	if !o.isSplitting() {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		return r, i + n, n > 0
+	}
+
+	// Gather the decoded bytes of a character along with the index just past
+	// each of them, skipping the zero bytes ending tokens unless they end a
+	// token partway through the character.
+	var buf [utf8.UTFMax]byte
+	var ends [utf8.UTFMax]int
+	n, escaped := 0, false
+gather:
+	for ; i < len(s) && !utf8.FullRune(buf[:n]); i++ {
+		b, ok, escape := decodeByte(s[i], escaped)
+		switch {
+		case ok:
+			buf[n], ends[n] = b, i+1
+			n++
+		case !escape && n > 0:
+			break gather
+		}
+		escaped = escape
+	}
+	if n == 0 {
+		return 0, 0, false
+	}
+	r, size := utf8.DecodeRune(buf[:n])
+	return r, ends[size-1], true
+}
+
+// segmentLabel returns the key segment seg as shown by the tree's debugging
+// output, where escaped is true if the segments before it end partway
+// through an escape sequence, and reports whether seg does. In a tree with a
+// splitter, escaped bytes are decoded and the end of each token is shown as
+// a vertical bar.
+func (o *options) segmentLabel(seg string, escaped bool) (string, bool) {
+	if !o.isSplitting() {
+		return seg, false
+	}
+	var b strings.Builder
+	for i := 0; i < len(seg); i++ {
+		c, ok, escape := decodeByte(seg[i], escaped)
+		switch {
+		case ok:
+			b.WriteByte(c)
+		case !escape:
+			b.WriteByte('|')
+		}
+		escaped = escape
+	}
+	return b.String(), escaped
+}
+
+// foldCase returns s with every rune replaced by its case-folded form if the
+// options select case folding, or s itself if they don't.
+func (o *options) foldCase(s string) string {
+	if !o.isCaseFolding() {
+		return s
+	}
+
+	// Strings whose bytes are all lowercase ASCII are already folded.
+	i := 0
+	for ; i < len(s); i++ {
+		if c := s[i]; c >= utf8.RuneSelf || ('A' <= c && c <= 'Z') {
+			break
+		}
+	}
+	if i == len(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	b.WriteString(s[:i])
+	for i < len(s) {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 {
+			b.WriteByte(s[i]) // leave invalid UTF-8 untouched
+		} else {
+			b.WriteRune(foldRune(r))
+		}
+		i += n
+	}
+	return b.String()
+}
+
+// foldRune returns the case-folded form of r, which is the least lowercase
+// rune among the runes equivalent to r under simple case folding, or the
+// least of those runes if none is lowercase.
+func foldRune(r rune) rune {
+	least, lower := r, unicode.IsLower(r)
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		switch l := unicode.IsLower(f); {
+		case l && !lower, l == lower && f < least:
+			least, lower = f, l
+		}
+	}
+	return least
+}
+
+// keyPrefixLen returns the length of the shortest prefix of key whose folded
+// form covers at least the first n bytes of path, the folded form of key.
+// It converts positions in the strings labeling the tree's links to
+// positions in the keys they spell.
+func (o *options) keyPrefixLen(key, path string, n int) int {
+	switch {
+	case o.isSplitting():
+		for l := 1; l < len(key); l++ {
+			if f := o.fold(key[:l]); len(f) >= n && strings.HasPrefix(path, f) {
+				return l
+			}
+		}
+		return len(key)
+	case !o.isCaseFolding():
+		return n
+	}
+
+	// Runes that fold to themselves may be divided anywhere, but any other
+	// rune is covered only by a prefix holding all of it.
+	l, m := 0, 0
+	for m < n && l < len(key) {
+		r, size := utf8.DecodeRuneInString(key[l:])
+		f := size
+		if fr := foldRune(r); fr != r {
+			f = utf8.RuneLen(fr)
+		} else if m+size > n {
+			return l + n - m
+		}
+		l, m = l+size, m+f
+	}
+	return l
+}
+
+// isSplitting returns true if the options include a splitter dividing keys
+// into tokens.
+func (o *options) isSplitting() bool {
+	return o != nil && o.split != nil
+}
+
+// linearSearchCutoff returns the number of links leaving a node at which
+// searches switch from a linear to a binary search of the links.
+func (o *options) linearSearchCutoff() int {
+	if o == nil || o.linearCutoff == 0 {
+		return defaultLinearSearchCutoff
+	}
+	return o.linearCutoff
+}
+
+// isTerminalAmbiguous returns true if the options select the
+// TerminalAmbiguous terminal prefix mode.
+func (o *options) isTerminalAmbiguous() bool {
+	return o != nil && o.terminalMode == TerminalAmbiguous
+}
+
+// acquire marks the tree as being mutated. If the tree checks for concurrent
+// use and is already being mutated, acquire panics.
+func (o *options) acquire() {
+	if o != nil && o.checkConcurrency && !o.inUse.CompareAndSwap(false, true) {
+		panic("prefixtree: concurrent use of prefixtree.Tree")
+	}
+}
+
+// release marks the tree as no longer being mutated.
+func (o *options) release() {
+	if o != nil && o.checkConcurrency {
+		o.inUse.Store(false)
+	}
+}
+
+// clone returns a copy of the options for use by a cloned tree. The copy is
+// not in use, and if metrics are enabled, it starts with fresh counters.
+func (o *options) clone() *options {
+	if o == nil {
+		return nil
+	}
+	c := &options{
+		uncompressed:       o.uncompressed,
+		runeAligned:        o.runeAligned,
+		caseFolding:        o.caseFolding,
+		casing:             o.casing,
+		split:              o.split,
+		valueSizer:         o.valueSizer,
+		linearCutoff:       o.linearCutoff,
+		checkConcurrency:   o.checkConcurrency,
+		terminalMode:       o.terminalMode,
+		slowQueryThreshold: o.slowQueryThreshold,
+		slowQueryHandler:   o.slowQueryHandler,
+	}
+	if o.metrics != nil {
+		c.metrics = new(queryCounters)
+	}
+	return c
+}
+
+// config returns the tree's options, allocating them if the tree was created
+// with the default configuration. It must only be called on the root of a
+// tree.
+func (t *Tree[V]) config() *options {
+	if t.opts == nil {
+		t.opts = new(options)
+	}
+	return t.opts
+}
+
+// A TerminalPrefixMode determines how a prefix tree resolves a prefix that
+// exactly matches a stored key when that key is itself a prefix of other
+// stored keys, such as the prefix "apple" in a tree holding both "apple" and
+// "applepie".
+type TerminalPrefixMode int
+
+const (
+	// TerminalWins resolves the prefix to the stored key it matches exactly.
+	// This is the default mode.
+	TerminalWins TerminalPrefixMode = iota
+
+	// TerminalAmbiguous treats the prefix as ambiguous, because it also
+	// matches the longer keys.
+	TerminalAmbiguous
+)
+
+// SetTerminalPrefixMode sets the mode used by the tree to resolve prefixes
+// that exactly match a stored key having other stored keys beneath it.
+func (t *Tree[V]) SetTerminalPrefixMode(mode TerminalPrefixMode) {
+	t.config().terminalMode = mode
+}
+
+// SetSlowQueryHandler installs a handler that is called whenever one of the
+// tree's Find methods (FindKey, FindKeyValue, FindValue, FindKeys,
+// FindKeyValues and FindValues) takes at least threshold to complete. The
+// handler receives the prefix searched for, the duration of the search and
+// the number of results it produced. Passing a nil handler removes any
+// previously installed handler, after which the Find methods perform no
+// timing at all.
+func (t *Tree[V]) SetSlowQueryHandler(threshold time.Duration, fn func(prefix string, d time.Duration, results int)) {
+	o := t.config()
+	o.slowQueryThreshold, o.slowQueryHandler = threshold, fn
+}
+
+// QueryMetrics holds statistics about the queries made against a prefix
+// tree by its Find methods since metrics were enabled or last reset.
+type QueryMetrics struct {
+	Queries      int64 // Number of Find calls
+	NotFound     int64 // Queries that matched no keys
+	Ambiguous    int64 // Queries that returned ErrPrefixAmbiguous
+	KeysReturned int64 // Total number of results returned by all queries
+}
+
+// queryCounters accumulates query metrics. Its counters are updated
+// atomically, so queries running concurrently on other goroutines are
+// counted correctly.
+type queryCounters struct {
+	queries, notFound, ambiguous, keysReturned atomic.Int64
+}
+
+// EnableMetrics makes the tree accumulate statistics about the queries made
+// by its Find methods (FindKey, FindKeyValue, FindValue, FindKeys,
+// FindKeyValues and FindValues), which are read with Metrics. Trees that
+// haven't enabled metrics do no counting at all. Enabling metrics on a tree
+// that already has them enabled has no effect.
+func (t *Tree[V]) EnableMetrics() {
+	if o := t.config(); o.metrics == nil {
+		o.metrics = new(queryCounters)
+	}
+}
+
+// Metrics returns the query statistics accumulated since EnableMetrics was
+// called or the metrics were last reset with ResetMetrics. If metrics aren't
+// enabled, all of the statistics are zero.
+func (t *Tree[V]) Metrics() QueryMetrics {
+	if t.opts == nil || t.opts.metrics == nil {
+		return QueryMetrics{}
+	}
+	m := t.opts.metrics
+	return QueryMetrics{
+		Queries:      m.queries.Load(),
+		NotFound:     m.notFound.Load(),
+		Ambiguous:    m.ambiguous.Load(),
+		KeysReturned: m.keysReturned.Load(),
+	}
+}
+
+// ResetMetrics sets all of the tree's accumulated query statistics to zero.
+func (t *Tree[V]) ResetMetrics() {
+	if t.opts == nil || t.opts.metrics == nil {
+		return
+	}
+	m := t.opts.metrics
+	m.queries.Store(0)
+	m.notFound.Store(0)
+	m.ambiguous.Store(0)
+	m.keysReturned.Store(0)
+}
+
+// observing returns true if the tree's queries are being timed or counted.
+func (o *options) observing() bool {
+	return o != nil && (o.slowQueryHandler != nil || o.metrics != nil)
+}
+
+// observe records a query that began at start, produced the given number of
+// results and failed with err, if it failed. The query is counted in the
+// tree's metrics and reported to the slow query handler if it took too long.
+func (o *options) observe(prefix string, start time.Time, results int, err error) {
+	if m := o.metrics; m != nil {
+		m.queries.Add(1)
+		m.keysReturned.Add(int64(results))
+		switch {
+		case err == ErrPrefixAmbiguous:
+			m.ambiguous.Add(1)
+		case results == 0:
+			m.notFound.Add(1)
+		}
+	}
+	if o.slowQueryHandler == nil {
+		return
+	}
+	if d := time.Since(start); d >= o.slowQueryThreshold {
+		o.slowQueryHandler(prefix, d, results)
+	}
+}
+
+// resultCount returns the number of results produced by a query returning a
+// single match and an error.
+func resultCount(err error) int {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,655 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSlowQueryHandler(t *testing.T) {
+	tree := New[int]()
+	for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+		tree.Add(key, i)
+	}
+
+	type report struct {
+		prefix  string
+		results int
+	}
+	var reports []report
+	tree.SetSlowQueryHandler(0, func(prefix string, d time.Duration, results int) {
+		reports = append(reports, report{prefix, results})
+	})
+
+	tree.FindKey("ap")
+	tree.FindKeyValue("arm")
+	tree.FindValue("c")
+	tree.FindKeys("a")
+	tree.FindKeyValues("b")
+	tree.FindValues("")
+
+	expected := []report{
+		{"ap", 0},
+		{"arm", 1},
+		{"c", 0},
+		{"a", 3},
+		{"b", 1},
+		{"", 4},
+	}
+	if len(reports) != len(expected) {
+		t.Fatalf("Slow query handler received %v, expected %v.\n", reports, expected)
+	}
+	for i := range expected {
+		if reports[i] != expected[i] {
+			t.Errorf("Case %d: slow query handler received %v, expected %v.\n",
+				i, reports[i], expected[i])
+		}
+	}
+
+	// Queries faster than the threshold aren't reported.
+	reports = nil
+	tree.SetSlowQueryHandler(time.Hour, func(prefix string, d time.Duration, results int) {
+		reports = append(reports, report{prefix, results})
+	})
+	tree.FindKeys("a")
+	if len(reports) != 0 {
+		t.Errorf("Slow query handler received %v, expected nothing.\n", reports)
+	}
+
+	// Removing the handler stops all reporting.
+	tree.SetSlowQueryHandler(0, nil)
+	tree.FindKeys("a")
+	if len(reports) != 0 {
+		t.Errorf("Slow query handler received %v after removal.\n", reports)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	tree := New[int]()
+	for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+		tree.Add(key, i)
+	}
+
+	// Queries made before metrics are enabled aren't counted.
+	tree.FindKeys("a")
+	if m := tree.Metrics(); m != (QueryMetrics{}) {
+		t.Errorf("Metrics before enabling returned %+v, expected zeros.\n", m)
+	}
+
+	tree.EnableMetrics()
+	tree.FindKey("ap")
+	tree.FindKeyValue("arm")
+	tree.FindValue("c")
+	tree.FindKeys("a")
+	tree.FindKeyValues("z")
+	tree.FindValues("")
+
+	expected := QueryMetrics{Queries: 6, NotFound: 2, Ambiguous: 1, KeysReturned: 8}
+	if m := tree.Metrics(); m != expected {
+		t.Errorf("Metrics returned %+v, expected %+v.\n", m, expected)
+	}
+
+	// Metrics and the slow query handler work side by side.
+	var handled int
+	tree.SetSlowQueryHandler(0, func(string, time.Duration, int) { handled++ })
+	tree.FindKey("b")
+	if m := tree.Metrics(); m.Queries != 7 || handled != 1 {
+		t.Errorf("Metrics counted %d queries and handler saw %d, expected 7 and 1.\n",
+			m.Queries, handled)
+	}
+
+	tree.ResetMetrics()
+	if m := tree.Metrics(); m != (QueryMetrics{}) {
+		t.Errorf("Metrics after reset returned %+v, expected zeros.\n", m)
+	}
+}
+
+func TestTerminalPrefixMode(t *testing.T) {
+	cases := []struct {
+		prefix   string
+		wins     error
+		strict   error
+		winsKeys []string
+	}{
+		{"a", ErrPrefixAmbiguous, ErrPrefixAmbiguous, []string{"apple", "apple pie"}},
+		{"apple", nil, ErrPrefixAmbiguous, []string{"apple"}},
+		{"apple p", nil, nil, []string{"apple pie"}},
+		{"lemon", nil, ErrPrefixAmbiguous, []string{"lemon"}},
+		{"lemon m", nil, nil, []string{"lemon meringue"}},
+		{"orange", nil, nil, []string{"orange"}},
+		{"oranges", ErrPrefixNotFound, ErrPrefixNotFound, []string{}},
+	}
+	strictKeys := map[string][]string{
+		"apple": {"apple", "apple pie"},
+		"lemon": {"lemon", "lemon meringue"},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, s := range []string{"apple", "orange", "apple pie", "lemon meringue", "lemon"} {
+			tree.Add(s, i)
+		}
+
+		for i, c := range cases {
+			if _, err := tree.FindKey(c.prefix); err != c.wins {
+				t.Errorf("Case %d: FindKey(\"%s\") returned %v with TerminalWins, expected %v.\n",
+					i, c.prefix, err, c.wins)
+			}
+			if keys := tree.FindKeys(c.prefix); !slices.Equal(keys, c.winsKeys) {
+				t.Errorf("Case %d: FindKeys(\"%s\") returned %v with TerminalWins, expected %v.\n",
+					i, c.prefix, keys, c.winsKeys)
+			}
+		}
+
+		tree.SetTerminalPrefixMode(TerminalAmbiguous)
+		for i, c := range cases {
+			if _, err := tree.FindKeyPreferExact(c.prefix); err != c.wins {
+				t.Errorf("Case %d: FindKeyPreferExact(\"%s\") returned %v with TerminalAmbiguous, expected %v.\n",
+					i, c.prefix, err, c.wins)
+			}
+			if _, err := tree.FindKey(c.prefix); err != c.strict {
+				t.Errorf("Case %d: FindKey(\"%s\") returned %v with TerminalAmbiguous, expected %v.\n",
+					i, c.prefix, err, c.strict)
+			}
+			expected := c.winsKeys
+			if keys, ok := strictKeys[c.prefix]; ok {
+				expected = keys
+			}
+			if keys := tree.FindKeys(c.prefix); !slices.Equal(keys, expected) {
+				t.Errorf("Case %d: FindKeys(\"%s\") returned %v with TerminalAmbiguous, expected %v.\n",
+					i, c.prefix, keys, expected)
+			}
+		}
+	}
+}
+
+func TestRuneBoundaries(t *testing.T) {
+	cases := []struct {
+		prefix    string
+		bytesErr  error
+		runesErr  error
+		runesKeys []string
+	}{
+		{"\xe6", ErrPrefixAmbiguous, ErrPrefixNotFound, []string{}},
+		{"\xe6\x97", ErrPrefixAmbiguous, ErrPrefixNotFound, []string{}},
+		{"日", ErrPrefixAmbiguous, ErrPrefixAmbiguous, []string{"日光", "日本"}},
+		{"日\xe6", nil, ErrPrefixNotFound, []string{}},
+		{"日本", nil, nil, []string{"日本"}},
+		{"旧", nil, nil, []string{"旧友"}},
+		{"a", nil, nil, []string{"apple"}},
+		{"b", ErrPrefixNotFound, ErrPrefixNotFound, []string{}},
+	}
+
+	for _, opts := range representations {
+		byteTree := New[int](opts...)
+		runeTree := New[int](append(opts, WithRuneBoundaries())...)
+		for i, key := range []string{"日本", "日光", "旧友", "apple"} {
+			byteTree.Add(key, i)
+			runeTree.Add(key, i)
+		}
+
+		for i, c := range cases {
+			if _, err := byteTree.FindKey(c.prefix); err != c.bytesErr {
+				t.Errorf("Case %d: FindKey(%q) returned %v without rune boundaries, expected %v.\n",
+					i, c.prefix, err, c.bytesErr)
+			}
+			if _, err := runeTree.FindKey(c.prefix); err != c.runesErr {
+				t.Errorf("Case %d: FindKey(%q) returned %v with rune boundaries, expected %v.\n",
+					i, c.prefix, err, c.runesErr)
+			}
+			if keys := runeTree.FindKeys(c.prefix); !slices.Equal(keys, c.runesKeys) {
+				t.Errorf("Case %d: FindKeys(%q) returned %v with rune boundaries, expected %v.\n",
+					i, c.prefix, keys, c.runesKeys)
+			}
+			if count, _ := runeTree.Summary(c.prefix, 0); count != len(c.runesKeys) {
+				t.Errorf("Case %d: Summary(%q) counted %d keys with rune boundaries, expected %d.\n",
+					i, c.prefix, count, len(c.runesKeys))
+			}
+		}
+
+		expected := map[string]int{"日本": 6, "日光": 6, "旧友": 3, "apple": 1}
+		lengths := runeTree.UniquePrefixLengths()
+		for key, n := range expected {
+			if lengths[key] != n {
+				t.Errorf("UniquePrefixLengths()[%q] returned %d with rune boundaries, expected %d.\n",
+					key, lengths[key], n)
+			}
+		}
+	}
+}
+
+func TestRuneBoundarySegments(t *testing.T) {
+	keys := []string{"日本", "月曜", "日曜", "日本語", "旧友", "apple", "arm"}
+
+	// checkSegments reports any link holding a partial character.
+	var checkSegments func(tree *Tree[int], path string)
+	checkSegments = func(tree *Tree[int], path string) {
+		for _, l := range tree.links {
+			if !utf8.ValidString(l.keyseg) {
+				t.Errorf("Link %q beneath %q holds a partial character.\n", l.keyseg, path)
+			}
+			checkSegments(l.tree, path+l.keyseg)
+		}
+	}
+
+	for _, opts := range representations {
+		byteTree := New[int](opts...)
+		runeTree := New[int](append(opts, WithRuneBoundaries())...)
+		sorted := New[int](append(opts, WithRuneBoundaries())...)
+		for i, key := range keys {
+			byteTree.Add(key, i)
+			runeTree.Add(key, i)
+		}
+		sorted.AddSorted(runeTree.SortedKeyValues())
+		checkSegments(runeTree, "")
+		checkSegments(sorted, "")
+		checkCounts(t, runeTree)
+		checkCounts(t, sorted)
+		if s1, s2 := sorted.String(), runeTree.String(); s1 != s2 {
+			t.Errorf("AddSorted built tree:\n%s\nexpected:\n%s\n", s1, s2)
+		}
+
+		for key, group := range runeTree.TopNPerBranch(2, func(a, b KeyValue[int]) bool { return a.Value < b.Value }) {
+			if !utf8.ValidString(key) {
+				t.Errorf("TopNPerBranch returned group %q holding %v.\n", key, group)
+			}
+		}
+		for _, kv := range runeTree.HeaviestPrefixes(10) {
+			if !utf8.ValidString(kv.Key) {
+				t.Errorf("HeaviestPrefixes returned prefix %q.\n", kv.Key)
+			}
+		}
+
+		// Searches of whole characters behave as they do without the option.
+		for i, prefix := range []string{"", "日", "日本", "日曜", "月", "旧", "a", "日本語です", "月曜日", "b", "z"} {
+			k1, err1 := byteTree.FindKey(prefix)
+			k2, err2 := runeTree.FindKey(prefix)
+			if k1 != k2 || err1 != err2 {
+				t.Errorf("Case %d: FindKey(%q) returned (%s, %v), expected (%s, %v).\n", i, prefix, k2, err2, k1, err1)
+			}
+			if ks1, ks2 := byteTree.FindKeys(prefix), runeTree.FindKeys(prefix); !slices.Equal(ks1, ks2) {
+				t.Errorf("Case %d: FindKeys(%q) returned %v, expected %v.\n", i, prefix, ks2, ks1)
+			}
+			f1, c1, _, _ := byteTree.Surround(prefix)
+			f2, c2, _, _ := runeTree.Surround(prefix)
+			if f1 != f2 || c1 != c2 {
+				t.Errorf("Case %d: Surround(%q) returned (%v, %v), expected (%v, %v).\n", i, prefix, f2, c2, f1, c1)
+			}
+			n1, _, _ := byteTree.FindNearest(prefix)
+			n2, _, _ := runeTree.FindNearest(prefix)
+			if n1 != n2 {
+				t.Errorf("Case %d: FindNearest(%q) returned %s, expected %s.\n", i, prefix, n2, n1)
+			}
+			l1, _, _ := byteTree.LongestPrefix(prefix)
+			l2, _, _ := runeTree.LongestPrefix(prefix)
+			if l1 != l2 {
+				t.Errorf("Case %d: LongestPrefix(%q) returned %s, expected %s.\n", i, prefix, l2, l1)
+			}
+		}
+
+		// The tree round-trips through WriteTo and ReadFrom.
+		var buf bytes.Buffer
+		runeTree.WriteTo(&buf)
+		loaded, err := ReadFrom[int](&buf, append(opts, WithRuneBoundaries())...)
+		if err != nil || loaded.String() != runeTree.String() {
+			t.Errorf("ReadFrom returned (%v, %v), expected\n%s\n", loaded, err, runeTree.String())
+		}
+
+		// Keys sharing leading bytes are still distinct characters for
+		// MergeDisjoint, and deleting keys keeps whole characters too.
+		other := New[int](append(opts, WithRuneBoundaries())...)
+		other.Add("早い", 10)
+		if err := runeTree.MergeDisjoint(other); err != nil {
+			t.Errorf("MergeDisjoint returned error: %v\n", err)
+		}
+		if n := runeTree.DeletePrefix("日"); n != 3 {
+			t.Errorf("DeletePrefix(\"日\") removed %d keys, expected 3.\n", n)
+		}
+		runeTree.Delete("月曜")
+		if keys := runeTree.Keys(); !slices.Equal(keys, []string{"apple", "arm", "旧友", "早い"}) {
+			t.Errorf("After deletions, Keys() returned %v.\n", keys)
+		}
+		checkSegments(runeTree, "")
+		checkCounts(t, runeTree)
+	}
+}
+
+func TestCaseFolding(t *testing.T) {
+	cases := []struct {
+		prefix string
+		key    string
+		err    error
+	}{
+		{"a", "", ErrPrefixAmbiguous},
+		{"APP", "", ErrPrefixAmbiguous},
+		{"applep", "ApplePie", nil},
+		{"APPLEPIE", "ApplePie", nil},
+		{"bAn", "banana", nil},
+		{"\u212a", "kelvin", nil}, // Kelvin sign
+		{"ΣΟΦ", "σοφία", nil},
+		{"İ", "İstanbul", nil},
+		{"i", "", ErrPrefixNotFound},
+		{"ı", "ısırgan", nil},
+		{"I", "", ErrPrefixNotFound},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](append(opts, WithCaseFolding())...)
+		for i, key := range []string{"Apple", "ApplePie", "banana", "kelvin", "σοφία", "İstanbul", "ısırgan"} {
+			tree.Add(key, i)
+		}
+
+		for i, c := range cases {
+			if key, err := tree.FindKey(c.prefix); key != c.key || err != c.err {
+				t.Errorf("Case %d: FindKey(%q) returned (%q, %v), expected (%q, %v).\n",
+					i, c.prefix, key, err, c.key, c.err)
+			}
+		}
+
+		expected := []string{"Apple", "ApplePie", "banana", "kelvin", "İstanbul", "ısırgan", "σοφία"}
+		if keys := tree.FindKeys(""); !slices.Equal(keys, expected) {
+			t.Errorf("FindKeys(\"\") returned %v, expected %v.\n", keys, expected)
+		}
+		if keys, next := tree.FindKeysPage("", "APPLEPIE", 2); !slices.Equal(keys, expected[2:4]) || next != "kelvin" {
+			t.Errorf("FindKeysPage(\"\", \"APPLEPIE\", 2) returned (%v, %q), expected (%v, \"kelvin\").\n",
+				keys, next, expected[2:4])
+		}
+		if value, ok := tree.Get("APPLE"); value != 0 || !ok {
+			t.Errorf("Get(\"APPLE\") returned (%d, %v), expected (0, true).\n", value, ok)
+		}
+
+		// Adding a key differing only in case replaces the stored key.
+		tree.Add("APPLE", 10)
+		if kv, err := tree.FindKeyValue("apple"); kv.Key != "APPLE" || kv.Value != 10 || err != nil {
+			t.Errorf("FindKeyValue(\"apple\") returned (%v, %v), expected ({APPLE 10}, nil).\n", kv, err)
+		}
+		if n := tree.Len(); n != 7 {
+			t.Errorf("Len() returned %d, expected 7.\n", n)
+		}
+
+		if !tree.Delete("applepie") || !tree.Delete("BANANA") {
+			t.Errorf("Delete failed to remove keys differing in case.\n")
+		}
+		if key, err := tree.FindKey("a"); key != "APPLE" || err != nil {
+			t.Errorf("FindKey(\"a\") after Delete returned (%q, %v), expected (\"APPLE\", nil).\n", key, err)
+		}
+	}
+}
+
+func TestCanonicalCasing(t *testing.T) {
+	cases := []struct {
+		opts []Option
+		keys []string
+	}{
+		{[]Option{WithCaseFolding()}, []string{"APPLE", "APPLEpie", "Arm"}},
+		{[]Option{WithCaseFolding(), WithCanonicalCasing(LastAdded)}, []string{"APPLE", "APPLEpie", "Arm"}},
+		{[]Option{WithCaseFolding(), WithCanonicalCasing(FirstAdded)}, []string{"Apple", "applePie", "arm"}},
+		{[]Option{WithCaseFolding(), WithoutCompression(), WithCanonicalCasing(FirstAdded)}, []string{"Apple", "applePie", "arm"}},
+	}
+
+	for i, c := range cases {
+		tree := New[int](c.opts...)
+		for j, key := range []string{"Apple", "apple", "applePie", "arm", "APPLE", "Arm", "APPLEpie"} {
+			tree.Add(key, j)
+		}
+
+		// Each folded key appears once, in its canonical form, with the
+		// value most recently added under any of its forms.
+		if keys := tree.FindKeys("a"); !slices.Equal(keys, c.keys) {
+			t.Errorf("Case %d: FindKeys(\"a\") returned %v, expected %v.\n", i, keys, c.keys)
+		}
+		if kv, err := tree.FindKeyValue("aPPLE"); kv.Key != c.keys[0] || kv.Value != 4 || err != nil {
+			t.Errorf("Case %d: FindKeyValue(\"aPPLE\") returned (%v, %v), expected (%s 4).\n", i, kv, err, c.keys[0])
+		}
+		if n := tree.Len(); n != 3 {
+			t.Errorf("Case %d: Len() returned %d, expected 3.\n", i, n)
+		}
+
+		// Once a key is deleted, the next form added becomes canonical.
+		tree.Delete("APPLE")
+		tree.Add("aPPle", 7)
+		if key, err := tree.FindKey("apple"); key != "aPPle" || err != nil {
+			t.Errorf("Case %d: FindKey(\"apple\") after re-adding returned (%s, %v), expected aPPle.\n", i, key, err)
+		}
+	}
+}
+
+func TestSplitter(t *testing.T) {
+	dots := func(s string) []string { return strings.Split(s, ".") }
+
+	cases := []struct {
+		prefix string
+		keys   []string
+	}{
+		{"", []string{"a.b", "a.b.c", "a.b\x00c", "a.bc", "x.y"}},
+		{"a", []string{"a.b", "a.b.c", "a.b\x00c", "a.bc"}},
+		{"a.b", []string{"a.b"}},
+		{"A.B", []string{"a.b"}},
+		{"a.b.c", []string{"a.b.c"}},
+		{"a.bc", []string{"a.bc"}},
+		{"a.", []string{}},
+		{"a.b.c.d", []string{}},
+		{"x", []string{"x.y"}},
+		{"x.y", []string{"x.y"}},
+		{"x.", []string{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](append(opts, WithSplitter(dots), WithCaseFolding())...)
+		for i, key := range []string{"x.y", "a.bc", "a.b.c", "a.b\x00c", "a.b"} {
+			tree.Add(key, i)
+		}
+
+		for i, c := range cases {
+			if keys := tree.FindKeys(c.prefix); !slices.Equal(keys, c.keys) {
+				t.Errorf("Case %d: FindKeys(%q) returned %q, expected %q.\n", i, c.prefix, keys, c.keys)
+			}
+		}
+		if key, err := tree.FindKey("a.b"); key != "a.b" || err != nil {
+			t.Errorf("FindKey(\"a.b\") returned (%q, %v), expected (\"a.b\", nil).\n", key, err)
+		}
+		allowed := func(c byte) bool { return c != 'c' }
+		if keys := tree.CompleteAllowed("A", allowed); !slices.Equal(keys, []string{"a.b"}) {
+			t.Errorf("CompleteAllowed(\"A\") returned %q, expected [\"a.b\"].\n", keys)
+		}
+		if key, _, ok := tree.LongestPrefix("a.b.cd"); key != "a.b" || !ok {
+			t.Errorf("LongestPrefix(\"a.b.cd\") returned (%q, %v), expected (\"a.b\", true).\n", key, ok)
+		}
+
+		if !tree.Delete("a.b") {
+			t.Errorf("Delete(\"a.b\") returned false.\n")
+		}
+		if keys := tree.FindKeys("a.b"); !slices.Equal(keys, []string{"a.b.c"}) {
+			t.Errorf("FindKeys(\"a.b\") after Delete returned %q, expected [\"a.b.c\"].\n", keys)
+		}
+		if n := tree.Len(); n != 4 {
+			t.Errorf("Len() returned %d, expected 4.\n", n)
+		}
+	}
+
+	// Splitting keys into bytes or characters matches prefixes exactly as a
+	// tree without a splitter does.
+	keys := []string{"apple", "applepie", "arm", "bee", "café", "cafés"}
+	plain := New[int]()
+	for i, key := range keys {
+		plain.Add(key, i)
+	}
+	for _, split := range []func(string) []string{SplitBytes, SplitRunes} {
+		tree := New[int](WithSplitter(split))
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		for _, key := range keys {
+			for i := 0; i <= len(key); i++ {
+				if !utf8.ValidString(key[:i]) {
+					continue // a partial character is no token of SplitRunes
+				}
+				v1, err1 := tree.FindValue(key[:i])
+				v2, err2 := plain.FindValue(key[:i])
+				if v1 != v2 || err1 != err2 {
+					t.Errorf("FindValue(%q) returned (%d, %v), expected (%d, %v).\n",
+						key[:i], v1, err1, v2, err2)
+				}
+			}
+		}
+		if got, expected := tree.FindKeys(""), plain.FindKeys(""); !slices.Equal(got, expected) {
+			t.Errorf("FindKeys(\"\") returned %q, expected %q.\n", got, expected)
+		}
+	}
+}
+
+func TestSplitterStructure(t *testing.T) {
+	dots := func(s string) []string { return strings.Split(s, ".") }
+	letters := func(c byte) bool { return 'a' <= c && c <= 'z' }
+
+	for _, opts := range representations {
+		tree := New[int](append(opts, WithSplitter(dots))...)
+		for i, key := range []string{"a.b", "a.b.c", "a.bc", "b.a", "x.y", "a.é", "a.è"} {
+			tree.Add(key, i)
+		}
+
+		// Methods walking the tree's structure see only the keys' tokens,
+		// never the bytes the tree uses to end them.
+		nextChars := []struct {
+			prefix string
+			chars  []rune
+		}{
+			{"", []rune{'a', 'b', 'x'}},
+			{"a", []rune{'b', 'è', 'é'}},
+			{"a.b", []rune{'c'}},
+			{"a.b.c", []rune{}},
+		}
+		for i, c := range nextChars {
+			if chars := tree.NextChars(c.prefix); !slices.Equal(chars, c.chars) {
+				t.Errorf("Case %d: NextChars(%q) returned %q, expected %q.\n", i, c.prefix, chars, c.chars)
+			}
+		}
+		if keys := tree.CompleteAllowed("a", letters); !slices.Equal(keys, []string{"a.b", "a.b.c", "a.bc"}) {
+			t.Errorf("CompleteAllowed(\"a\") returned %q, expected [a.b a.b.c a.bc].\n", keys)
+		}
+		if keys := tree.FindAnagrams("b.a"); !slices.Equal(keys, []string{"a.b", "b.a"}) {
+			t.Errorf("FindAnagrams(\"b.a\") returned %q, expected [a.b b.a].\n", keys)
+		}
+		if keys := tree.SuggestCorrections("a.b.x", 1, 0); !slices.Equal(keys, []string{"a.b", "a.b.c"}) {
+			t.Errorf("SuggestCorrections(\"a.b.x\", 1, 0) returned %q, expected [a.b a.b.c].\n", keys)
+		}
+		if kvs := tree.FindFuzzy("a.b.x", 1); !slices.Equal(kvs, []KeyValue[int]{{"a.b", 0}, {"a.b.c", 1}}) {
+			t.Errorf("FindFuzzy(\"a.b.x\", 1) returned %v, expected [{a.b 0} {a.b.c 1}].\n", kvs)
+		}
+		expected := []KeyValue[int]{{"a", 5}, {"a.b", 2}}
+		if prefixes := tree.HeaviestPrefixes(10); !slices.Equal(prefixes, expected) {
+			t.Errorf("HeaviestPrefixes(10) returned %v, expected %v.\n", prefixes, expected)
+		}
+		for label := range tree.TopNPerBranch(1, func(a, b KeyValue[int]) bool { return a.Value < b.Value }) {
+			if strings.IndexByte(label, 0) >= 0 {
+				t.Errorf("TopNPerBranch returned group %q.\n", label)
+			}
+		}
+		if s := tree.String(); strings.IndexByte(s, 0) >= 0 {
+			t.Errorf("String returned:\n%q\n", s)
+		}
+
+		// Tokens holding the bytes the tree escapes are decoded even when a
+		// link ends partway through an escape sequence.
+		escapes := New[int](append(opts, WithSplitter(dots))...)
+		escapes.Add("a\x00.x", 0)
+		escapes.Add("a\x01.y", 1)
+		if keys := escapes.CompleteAllowed("", func(c byte) bool { return c != 1 }); !slices.Equal(keys, []string{"a\x00.x"}) {
+			t.Errorf("CompleteAllowed(\"\") returned %q, expected [\"a\\x00.x\"].\n", keys)
+		}
+		if chars := escapes.NextChars("a\x01"); !slices.Equal(chars, []rune{'y'}) {
+			t.Errorf("NextChars(\"a\\x01\") returned %q, expected ['y'].\n", chars)
+		}
+	}
+
+	// String shows the end of each token as a vertical bar.
+	tree := New[int](WithSplitter(dots))
+	tree.Add("a\x00.x", 0)
+	tree.Add("a\x01.y", 1)
+	expected := "Node: key=\"\" term=false desc=2 value=0\n" +
+		"  Link 0: ks=\"a\"\n" +
+		"    Node: key=\"\" term=false desc=2 value=0\n" +
+		"      Link 0: ks=\"\x00|x|\"\n" +
+		"        Node: key=\"a\x00.x\" term=true desc=1 value=0\n" +
+		"      Link 1: ks=\"\x01|y|\"\n" +
+		"        Node: key=\"a\x01.y\" term=true desc=1 value=1\n"
+	if s := tree.String(); s != expected {
+		t.Errorf("String returned:\n%q\nexpected:\n%q\n", s, expected)
+	}
+}
+
+func TestLinearSearchCutoff(t *testing.T) {
+	// Give the root a link for every letter, so searches of it are binary
+	// under small cutoffs and linear under large ones.
+	var keys []string
+	for c := 'a'; c <= 'z'; c++ {
+		keys = append(keys, string(c)+"pple", string(c)+"rm", string(c))
+	}
+
+	plain := New[int]()
+	for i, key := range keys {
+		plain.Add(key, i)
+	}
+	for _, cutoff := range []int{-1, 1, 2, 26, 27, 1000} {
+		tree := New[int](WithLinearSearchCutoff(cutoff))
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		for _, key := range append(keys, "", "0", "ap", "zr", "zz", "~") {
+			v1, err1 := tree.FindValue(key)
+			v2, err2 := plain.FindValue(key)
+			if v1 != v2 || err1 != err2 {
+				t.Errorf("With cutoff %d, FindValue(%q) returned (%d, %v), expected (%d, %v).\n",
+					cutoff, key, v1, err1, v2, err2)
+			}
+		}
+	}
+}
+
+func TestConcurrencyCheck(t *testing.T) {
+	mutations := []struct {
+		name string
+		fn   func(tree *Tree[int])
+	}{
+		{"Add", func(tree *Tree[int]) { tree.Add("apple", 1) }},
+		{"RemapValues", func(tree *Tree[int]) {
+			tree.RemapValues(func(key string, old int) int { return old })
+		}},
+	}
+
+	for _, m := range mutations {
+		// Simulate a mutation already in progress on another goroutine.
+		tree := New[int](WithConcurrencyCheck())
+		tree.Add("bee", 2)
+		tree.opts.inUse.Store(true)
+
+		func() {
+			defer func() {
+				r := recover()
+				if msg, _ := r.(string); !strings.Contains(msg, "concurrent use") {
+					t.Errorf("%s panicked with %v, expected a concurrent use panic.\n", m.name, r)
+				}
+			}()
+			m.fn(tree)
+		}()
+
+		// Once the other mutation completes, the tree is usable again.
+		tree.opts.inUse.Store(false)
+		m.fn(tree)
+		if tree.opts.inUse.Load() {
+			t.Errorf("%s left the tree marked as in use.\n", m.name)
+		}
+	}
+
+	// Without the option, no check is made.
+	tree := New[int]()
+	tree.config().inUse.Store(true)
+	tree.Add("apple", 1)
+}
@@ -9,10 +9,17 @@
 package prefixtree
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
 	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -23,6 +30,18 @@ var (
 	// ErrPrefixAmbiguous is returned by Find if the prefix being
 	// searched for matches more than one string in the prefix tree.
 	ErrPrefixAmbiguous = errors.New("prefixtree: prefix ambiguous")
+
+	// ErrInvalidEncoding is returned when decoding a serialized prefix tree
+	// from malformed data.
+	ErrInvalidEncoding = errors.New("prefixtree: invalid encoding")
+
+	// ErrUnsupportedVersion is returned by Load if the data was saved in a
+	// format version this package doesn't understand.
+	ErrUnsupportedVersion = errors.New("prefixtree: unsupported format version")
+
+	// ErrKeyspaceOverlap is returned by MergeDisjoint if the keys of the
+	// trees being merged share a first character.
+	ErrKeyspaceOverlap = errors.New("prefixtree: keyspaces overlap")
 )
 
 // A KeyValue type encapsulates a key string and its associated value of type
@@ -32,14 +51,51 @@ type KeyValue[V any] struct {
 	Value V
 }
 
+// A FindResult holds the outcome of one of the searches made by a batch
+// query such as FindValuesBatch: the value found, or the error that would
+// have been returned by the corresponding single search.
+type FindResult[V any] struct {
+	Value V
+	Err   error
+}
+
+// A MatchState describes how a prefix matches the keys of a prefix tree, as
+// reported by Match.
+type MatchState int
+
+const (
+	// MatchNotFound indicates that the prefix matches no key.
+	MatchNotFound MatchState = iota
+
+	// MatchUnique indicates that the prefix matches exactly one key, so the
+	// Find methods resolve it without error.
+	MatchUnique
+
+	// MatchAmbiguous indicates that the prefix matches more than one key.
+	MatchAmbiguous
+)
+
 // A Tree represents a prefix tree containing strings and their associated
 // value data of type V. The tree is implemented as a trie and can be searched
 // efficiently for unique prefix matches.
+//
+// The key segments labeling the tree's links are substrings of the keys added
+// to the tree, so they share memory with the full keys retained by terminal
+// nodes. The string data of each key is therefore stored only once, except
+// in case-folding trees, whose links are labeled with case-folded copies of
+// keys that contain uppercase or non-ASCII characters, and in trees with a
+// splitter, whose links are labeled with copies of keys divided into tokens.
+//
+// A Tree is not safe for concurrent use. Any number of goroutines may search
+// a tree at once, but a tree must not be modified while any other goroutine
+// is using it. Use a SyncTree to share a tree between goroutines that modify
+// it.
 type Tree[V any] struct {
 	key         string
 	value       V
 	links       []link[V]
 	descendants int
+	opts        *options
 }
 
 type link[V any] struct {
@@ -47,9 +103,17 @@ type link[V any] struct {
 	tree   *Tree[V]
 }
 
-// New returns an empty prefix tree with a value type of V.
-func New[V any]() *Tree[V] {
-	return new(Tree[V])
+// New returns an empty prefix tree with a value type of V. Options may be
+// provided to configure the tree.
+func New[V any](opts ...Option) *Tree[V] {
+	t := new(Tree[V])
+	if len(opts) > 0 {
+		t.opts = new(options)
+		for _, opt := range opts {
+			opt(t.opts)
+		}
+	}
+	return t
 }
 
 // isTerminal returns true if the tree is a terminal subtree in the
@@ -63,7 +127,51 @@ func (t *Tree[V]) isTerminal() bool {
 // ErrPrefixNotFound is returned. If the prefix matches more than one key in
 // the tree, ErrPrefixAmbiguous is returned.
 func (t *Tree[V]) FindKey(prefix string) (key string, err error) {
+	if t.opts.observing() {
+		start := time.Now()
+		defer func() { t.opts.observe(prefix, start, resultCount(err), err) }()
+	}
+
+	st, err := t.findSubtree(prefix)
+	if err != nil {
+		return "", err
+	}
+	return st.key, nil
+}
+
+// FindKeyOrCandidates searches the prefix tree for a key string that
+// uniquely matches the prefix, as FindKey does. If found, the full matching
+// key is returned. If the prefix matches more than one key in the tree,
+// ErrPrefixAmbiguous is returned along with all of the matching keys in
+// lexicographic order, which are collected during the same search. If not
+// found, ErrPrefixNotFound is returned.
+func (t *Tree[V]) FindKeyOrCandidates(prefix string) (key string, candidates []string, err error) {
+	st, err := t.findSubtree(prefix)
+	switch err {
+	case nil:
+		return st.key, nil, nil
+	case ErrPrefixAmbiguous:
+		return "", appendDescendantKeys(st, make([]string, 0, st.descendants)), err
+	default:
+		return "", nil, err
+	}
+}
+
+// FindKeyPreferExact searches the prefix tree for a key string matching the
+// prefix, preferring a key that exactly matches the prefix over longer keys
+// that start with it, as command dispatchers commonly do: with "add" and
+// "address" stored, the prefix "add" resolves to "add". Otherwise it behaves
+// like FindKey, returning ErrPrefixNotFound or ErrPrefixAmbiguous if the
+// prefix matches no key or more than one key. This is how FindKey resolves
+// prefixes in the default TerminalWins mode; FindKeyPreferExact resolves
+// them this way regardless of the tree's terminal prefix mode.
+func (t *Tree[V]) FindKeyPreferExact(prefix string) (string, error) {
 	st, err := t.findSubtree(prefix)
+	if err == ErrPrefixAmbiguous {
+		if n := t.findExact(prefix); n != nil {
+			return n.key, nil
+		}
+	}
 	if err != nil {
 		return "", err
 	}
@@ -76,6 +184,11 @@ func (t *Tree[V]) FindKey(prefix string) (key string, err error) {
 // prefix matches more than one key in the tree, ErrPrefixAmbiguous is
 // returned.
 func (t *Tree[V]) FindKeyValue(prefix string) (kv KeyValue[V], err error) {
+	if t.opts.observing() {
+		start := time.Now()
+		defer func() { t.opts.observe(prefix, start, resultCount(err), err) }()
+	}
+
 	st, err := t.findSubtree(prefix)
 	if err != nil {
 		return KeyValue[V]{}, err
@@ -83,9 +196,112 @@ func (t *Tree[V]) FindKeyValue(prefix string) (kv KeyValue[V], err error) {
 	return KeyValue[V]{st.key, st.value}, nil
 }
 
+// FindKeyValueExactness searches the prefix tree for a key string that
+// uniquely matches the prefix, as FindKeyValue does, and also reports whether
+// the prefix is the matching key itself rather than an abbreviation of it.
+// If found, the full matching key and its associated value are returned,
+// with exact set to true if the prefix equals the key. In a case-folding
+// tree, the prefix need only equal the key when both are case-folded. If not
+// found, ErrPrefixNotFound is returned. If the prefix matches more than one
+// key in the tree, ErrPrefixAmbiguous is returned.
+func (t *Tree[V]) FindKeyValueExactness(prefix string) (kv KeyValue[V], exact bool, err error) {
+	st, err := t.findSubtree(prefix)
+	if err != nil {
+		return KeyValue[V]{}, false, err
+	}
+	exact = prefix == st.key || t.opts.fold(prefix) == t.opts.fold(st.key)
+	return KeyValue[V]{st.key, st.value}, exact, nil
+}
+
+// FindUpTo searches the prefix tree for the keys matching the prefix,
+// tolerating up to limit of them. If the prefix resolves to a single key, as
+// it would for FindKeyValue, that key and its value are returned. Otherwise,
+// if the prefix matches no more than limit keys, all of them are returned
+// with their values in lexicographic key order. If it matches more than limit
+// keys, ErrPrefixAmbiguous is returned, and if it matches none,
+// ErrPrefixNotFound is returned. The number of matching keys is known before
+// any of them are collected, so a prefix matching too many keys costs no more
+// to reject than a FindKeyValue call. A limit of less than one is treated as
+// one.
+func (t *Tree[V]) FindUpTo(prefix string, limit int) ([]KeyValue[V], error) {
+	st, err := t.findSubtree(prefix)
+	switch {
+	case err == nil:
+		return []KeyValue[V]{{st.key, st.value}}, nil
+	case err == ErrPrefixNotFound || st.descendants == 0:
+		return nil, ErrPrefixNotFound
+	case st.descendants > limit:
+		return nil, ErrPrefixAmbiguous
+	}
+	return appendDescendantKeyValues(st, make([]KeyValue[V], 0, st.descendants)), nil
+}
+
+// Lookup searches the prefix tree for a key string that uniquely matches
+// the prefix, returning the matching key and its value. It is equivalent to
+// FindKeyValue, except that it returns false instead of an error when the
+// prefix matches no key or more than one key.
+func (t *Tree[V]) Lookup(prefix string) (KeyValue[V], bool) {
+	kv, err := t.FindKeyValue(prefix)
+	return kv, err == nil
+}
+
+// Match reports whether the prefix matches no key in the prefix tree, a
+// unique key, or more than one key, exactly as FindKey would resolve it but
+// without returning the key or an error.
+func (t *Tree[V]) Match(prefix string) MatchState {
+	switch _, err := t.findSubtree(prefix); err {
+	case nil:
+		return MatchUnique
+	case ErrPrefixAmbiguous:
+		return MatchAmbiguous
+	default:
+		return MatchNotFound
+	}
+}
+
+// Resolve searches the prefix tree for the keys matching the prefix and
+// reports how the prefix matches them, as Match does, along with the result
+// appropriate to each state: on MatchUnique, the matching key and its value
+// are returned in kv; on MatchAmbiguous, all of the matching keys are
+// returned with their values in candidates, in lexicographic key order; and
+// on MatchNotFound, neither is returned. The candidates are collected during
+// the same search that finds the prefix to be ambiguous.
+func (t *Tree[V]) Resolve(prefix string) (kv KeyValue[V], candidates []KeyValue[V], state MatchState) {
+	st, err := t.findSubtree(prefix)
+	switch err {
+	case nil:
+		return KeyValue[V]{st.key, st.value}, nil, MatchUnique
+	case ErrPrefixAmbiguous:
+		candidates = appendDescendantKeyValues(st, make([]KeyValue[V], 0, st.descendants))
+		return KeyValue[V]{}, candidates, MatchAmbiguous
+	default:
+		return KeyValue[V]{}, nil, MatchNotFound
+	}
+}
+
+// IsAmbiguous returns true if the prefix matches more than one key in the
+// prefix tree, in which case FindKey would return ErrPrefixAmbiguous.
+func (t *Tree[V]) IsAmbiguous(prefix string) bool {
+	return t.Match(prefix) == MatchAmbiguous
+}
+
+// PrefixExists returns true if at least one key in the prefix tree starts
+// with the provided prefix, whether the prefix matches it uniquely or is
+// ambiguous. No keys are collected, so it is cheaper than checking the
+// length of the slice returned by FindKeys.
+func (t *Tree[V]) PrefixExists(prefix string) bool {
+	st, err := t.findSubtree(prefix)
+	return err != ErrPrefixNotFound && st.descendants > 0
+}
+
 // FindKeys searches the prefix tree for all key strings prefixed by the
 // provided prefix and returns them.
 func (t *Tree[V]) FindKeys(prefix string) (keys []string) {
+	if t.opts.observing() {
+		start := time.Now()
+		defer func() { t.opts.observe(prefix, start, len(keys), nil) }()
+	}
+
 	st, err := t.findSubtree(prefix)
 	if err == ErrPrefixNotFound {
 		return []string{}
@@ -96,11 +312,115 @@ func (t *Tree[V]) FindKeys(prefix string) (keys []string) {
 	return appendDescendantKeys(st, nil)
 }
 
+// AppendKeys searches the prefix tree for all key strings prefixed by the
+// provided prefix, as FindKeys does, and appends them to dst, returning the
+// extended slice. Passing dst[:0] reuses the memory of a slice returned by an
+// earlier call, so repeated searches need not allocate. If no keys match, dst
+// is returned unchanged.
+func (t *Tree[V]) AppendKeys(dst []string, prefix string) []string {
+	st, err := t.findSubtree(prefix)
+	if err == ErrPrefixNotFound {
+		return dst
+	}
+	if st.isTerminal() && err != ErrPrefixAmbiguous {
+		return append(dst, st.key)
+	}
+	return appendDescendantKeys(st, dst)
+}
+
+// FindKeysLimit searches the prefix tree for key strings prefixed by the
+// provided prefix and returns the first limit of them in lexicographic
+// order. The search of the tree stops once limit keys are found. A limit of
+// zero or less returns all matching keys, as FindKeys does.
+func (t *Tree[V]) FindKeysLimit(prefix string, limit int) []string {
+	if limit <= 0 {
+		return t.FindKeys(prefix)
+	}
+
+	st, err := t.findSubtree(prefix)
+	if err == ErrPrefixNotFound {
+		return []string{}
+	}
+	if st.isTerminal() && err != ErrPrefixAmbiguous {
+		return []string{st.key}
+	}
+	keys := make([]string, 0, min(limit, st.descendants))
+	walkDescendants(st, func(n *Tree[V]) bool {
+		keys = append(keys, n.key)
+		return len(keys) < limit
+	})
+	return keys
+}
+
+// FindKeysDesc searches the prefix tree for all key strings prefixed by the
+// provided prefix and returns them in descending lexicographic order. It
+// matches the same keys as FindKeys, in the reverse order.
+func (t *Tree[V]) FindKeysDesc(prefix string) []string {
+	st, err := t.findSubtree(prefix)
+	if err == ErrPrefixNotFound {
+		return []string{}
+	}
+	if st.isTerminal() && err != ErrPrefixAmbiguous {
+		return []string{st.key}
+	}
+	return appendDescendantKeysDesc(st, make([]string, 0, st.descendants))
+}
+
+// FindKeysPage searches the prefix tree for key strings prefixed by the
+// provided prefix and returns, in lexicographic order, up to limit of them
+// that are greater than after. It matches the same keys as FindKeys, so the
+// pages returned by successive calls together hold the keys FindKeys would
+// return. An empty after starts with the first matching key. If more keys
+// follow the page, next is the last key in the page, to be passed as after
+// to retrieve the next page; otherwise next is empty. The search skips the
+// parts of the tree ordered before after and stops once the page is full. A
+// limit of zero or less returns all matching keys greater than after.
+func (t *Tree[V]) FindKeysPage(prefix, after string, limit int) (keys []string, next string) {
+	st, err := t.findSubtree(prefix)
+	if err == ErrPrefixNotFound {
+		return []string{}, ""
+	}
+	after = t.opts.fold(after)
+	if st.isTerminal() && err != ErrPrefixAmbiguous {
+		if t.opts.fold(st.key) > after {
+			return []string{st.key}, ""
+		}
+		return []string{}, ""
+	}
+	st, depth := t.prefixSubtree(prefix)
+	if st == nil {
+		return []string{}, ""
+	}
+
+	// Collect one key beyond the page, to learn whether more keys follow.
+	keys = []string{}
+	collect := func(n *Tree[V]) bool {
+		keys = append(keys, n.key)
+		return limit <= 0 || len(keys) <= limit
+	}
+	switch path := t.opts.fold(firstDescendant(st).key)[:depth]; {
+	case strings.HasPrefix(after, path):
+		walkDescendantsAfter(st, after, depth, collect)
+	case after < path:
+		walkDescendants(st, collect)
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+	return keys, next
+}
+
 // FindValue searches the prefix tree for a key string that uniquely matches
 // the prefix. If found, the value associated with the key is returned. If not
 // found, ErrPrefixNotFound is returned. If the prefix matches more than one
 // key in the tree, ErrPrefixAmbiguous is returned.
 func (t *Tree[V]) FindValue(prefix string) (value V, err error) {
+	if t.opts.observing() {
+		start := time.Now()
+		defer func() { t.opts.observe(prefix, start, resultCount(err), err) }()
+	}
+
 	st, err := t.findSubtree(prefix)
 	if err != nil {
 		var empty V
@@ -109,9 +429,69 @@ func (t *Tree[V]) FindValue(prefix string) (value V, err error) {
 	return st.value, nil
 }
 
+// FindValuesBatch searches the prefix tree for each of the provided
+// prefixes, as FindValue does, and returns the results in the same order as
+// the prefixes. The prefixes are searched in sorted order, and each search
+// resumes from the deepest node reached by the previous search along the
+// links the two prefixes share, so prefixes with common beginnings share the
+// walk down the tree.
+func (t *Tree[V]) FindValuesBatch(prefixes []string) []FindResult[V] {
+	results := make([]FindResult[V], len(prefixes))
+	folded := make([]string, len(prefixes))
+	order := make([]int, len(prefixes))
+	for i, prefix := range prefixes {
+		folded[i], order[i] = t.opts.fold(prefix), i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return strings.Compare(folded[a], folded[b])
+	})
+
+	// The stack holds the nodes reached by whole links along the previous
+	// prefix, with the number of bytes of the prefix leading to each.
+	type step struct {
+		n     *Tree[V]
+		depth int
+	}
+	stack, prev := []step{{t, 0}}, ""
+	for _, i := range order {
+		prefix := folded[i]
+		if t.opts.splitsRune(prefix) {
+			results[i].Err = ErrPrefixNotFound
+			continue
+		}
+
+		shared := matchingChars(prev, prefix)
+		for stack[len(stack)-1].depth > shared {
+			stack = stack[:len(stack)-1]
+		}
+		for top := stack[len(stack)-1]; top.depth < len(prefix); {
+			ix := top.n.prefixLink(prefix[top.depth:])
+			if ix < 0 {
+				break
+			}
+			top = step{top.n.links[ix].tree, top.depth + len(top.n.links[ix].keyseg)}
+			stack = append(stack, top)
+		}
+		prev = prefix
+
+		top := stack[len(stack)-1]
+		st, err := t.findSubtreeFrom(top.n, prefix[top.depth:], len(prefix) > 0)
+		if err == nil {
+			results[i].Value = st.value
+		}
+		results[i].Err = err
+	}
+	return results
+}
+
 // FindKeyValues searches the prefix tree for all key strings prefixed by the
 // provided prefix. All discovered keys and their values are returned.
 func (t *Tree[V]) FindKeyValues(prefix string) (values []KeyValue[V]) {
+	if t.opts.observing() {
+		start := time.Now()
+		defer func() { t.opts.observe(prefix, start, len(values), nil) }()
+	}
+
 	st, err := t.findSubtree(prefix)
 	if err == ErrPrefixNotFound {
 		return []KeyValue[V]{}
@@ -122,9 +502,93 @@ func (t *Tree[V]) FindKeyValues(prefix string) (values []KeyValue[V]) {
 	return appendDescendantKeyValues(st, nil)
 }
 
+// AppendKeyValues searches the prefix tree for all key strings prefixed by
+// the provided prefix, as FindKeyValues does, and appends the discovered keys
+// and their values to dst, returning the extended slice. Like AppendKeys, it
+// lets a slice be reused across searches.
+func (t *Tree[V]) AppendKeyValues(dst []KeyValue[V], prefix string) []KeyValue[V] {
+	st, err := t.findSubtree(prefix)
+	if err == ErrPrefixNotFound {
+		return dst
+	}
+	if st.isTerminal() && err != ErrPrefixAmbiguous {
+		return append(dst, KeyValue[V]{st.key, st.value})
+	}
+	return appendDescendantKeyValues(st, dst)
+}
+
+// FindKeyValuesFunc searches the prefix tree for all key strings prefixed by
+// the provided prefix, as FindKeyValues does, and returns the discovered keys
+// and their values for which keep returns true. The filter is applied during
+// the search, so entries it rejects are never added to the result.
+func (t *Tree[V]) FindKeyValuesFunc(prefix string, keep func(kv KeyValue[V]) bool) []KeyValue[V] {
+	values := []KeyValue[V]{}
+	st, err := t.findSubtree(prefix)
+	if err == ErrPrefixNotFound {
+		return values
+	}
+	if st.isTerminal() && err != ErrPrefixAmbiguous {
+		if kv := (KeyValue[V]{st.key, st.value}); keep(kv) {
+			values = append(values, kv)
+		}
+		return values
+	}
+	walkDescendants(st, func(n *Tree[V]) bool {
+		if kv := (KeyValue[V]{n.key, n.value}); keep(kv) {
+			values = append(values, kv)
+		}
+		return true
+	})
+	return values
+}
+
+// contextCheckInterval is the number of keys collected between checks of a
+// context for cancellation.
+const contextCheckInterval = 1024
+
+// FindKeyValuesContext searches the prefix tree for all key strings prefixed
+// by the provided prefix and returns them with their values, as FindKeyValues
+// does, unless the context is canceled or its deadline passes first. The
+// context is checked before the search and then after every 1024 keys
+// collected, and if it is done, the search stops and the context's error is
+// returned. This bounds the time spent enumerating a large tree on behalf of
+// a request that has been abandoned.
+func (t *Tree[V]) FindKeyValuesContext(ctx context.Context, prefix string) ([]KeyValue[V], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	st, err := t.findSubtree(prefix)
+	if err == ErrPrefixNotFound {
+		return []KeyValue[V]{}, nil
+	}
+	if st.isTerminal() && err != ErrPrefixAmbiguous {
+		return []KeyValue[V]{{st.key, st.value}}, nil
+	}
+
+	var values []KeyValue[V]
+	var ctxErr error
+	walkDescendants(st, func(n *Tree[V]) bool {
+		values = append(values, KeyValue[V]{n.key, n.value})
+		if len(values)%contextCheckInterval == 0 {
+			ctxErr = ctx.Err()
+		}
+		return ctxErr == nil
+	})
+	if ctxErr != nil {
+		return nil, ctxErr
+	}
+	return values, nil
+}
+
 // FindValues searches the prefix tree for all key strings prefixed by the
 // provided prefix. All associated values are returned.
 func (t *Tree[V]) FindValues(prefix string) (values []V) {
+	if t.opts.observing() {
+		start := time.Now()
+		defer func() { t.opts.observe(prefix, start, len(values), nil) }()
+	}
+
 	st, err := t.findSubtree(prefix)
 	if err == ErrPrefixNotFound {
 		return []V{}
@@ -135,120 +599,1025 @@ func (t *Tree[V]) FindValues(prefix string) (values []V) {
 	return appendDescendantValues(st, nil)
 }
 
-// findSubtree searches the prefix tree for the deepest subtree matching
-// the prefix.
-func (t *Tree[V]) findSubtree(prefix string) (*Tree[V], error) {
-outerLoop:
-	for {
-		// Ran out of prefix?
-		if len(prefix) == 0 {
-			if t.isTerminal() {
-				return t, nil
-			}
-			return t, ErrPrefixAmbiguous
-		}
+// AppendValues searches the prefix tree for all key strings prefixed by the
+// provided prefix, as FindValues does, and appends their associated values
+// to dst, returning the extended slice. Like AppendKeys, it lets a slice be
+// reused across searches.
+func (t *Tree[V]) AppendValues(dst []V, prefix string) []V {
+	st, err := t.findSubtree(prefix)
+	if err == ErrPrefixNotFound {
+		return dst
+	}
+	if st.isTerminal() && err != ErrPrefixAmbiguous {
+		return append(dst, st.value)
+	}
+	return appendDescendantValues(st, dst)
+}
 
-		// Figure out which links to consider. If the number of links from the
-		// node is large-ish (20+), do a binary search for 2 candidate links.
-		// Otherwise search all links. The cutoff point between binary and
-		// linear search was determined by benchmarking against the unix
-		// english dictionary.
-		start, stop := 0, len(t.links)-1
-		if len(t.links) >= 20 {
-			ix := sort.Search(len(t.links),
-				func(i int) bool { return t.links[i].keyseg >= prefix })
-			start, stop = max(0, ix-1), min(ix, stop)
+// Keys returns all keys stored in the prefix tree, in lexicographic order.
+func (t *Tree[V]) Keys() []string {
+	return appendDescendantKeys(t, make([]string, 0, t.descendants))
+}
+
+// Values returns the values associated with all keys stored in the prefix
+// tree, in the lexicographic order of their keys.
+func (t *Tree[V]) Values() []V {
+	return appendDescendantValues(t, make([]V, 0, t.descendants))
+}
+
+// SortedKeys returns all keys stored in the prefix tree in ascending order
+// of their bytes, the order used by sort.SearchStrings and slices.Sort, so
+// the result may be binary searched directly. Most trees store their keys in
+// this order and return them without sorting. Trees matching keys in a
+// transformed form, such as case-folding trees, order their keys by that
+// form, so their keys are sorted before being returned.
+func (t *Tree[V]) SortedKeys() []string {
+	keys := t.Keys()
+	if t.opts.isCaseFolding() || t.opts.isSplitting() {
+		slices.Sort(keys)
+	}
+	return keys
+}
+
+// SortedKeyValues returns all keys stored in the prefix tree and their
+// associated values, in ascending order of the keys' bytes, as SortedKeys
+// orders them.
+func (t *Tree[V]) SortedKeyValues() []KeyValue[V] {
+	kvs := appendDescendantKeyValues(t, make([]KeyValue[V], 0, t.descendants))
+	if t.opts.isCaseFolding() || t.opts.isSplitting() {
+		slices.SortFunc(kvs, func(a, b KeyValue[V]) int {
+			return strings.Compare(a.Key, b.Key)
+		})
+	}
+	return kvs
+}
+
+// ResolveStream consumes runes from a channel until the runes received so
+// far uniquely match a key in the prefix tree, at which point the key and its
+// associated value are returned without waiting for further input. If the
+// runes received so far match no key, ErrPrefixNotFound is returned
+// immediately. If the channel is closed before the input resolves to a key,
+// ErrPrefixAmbiguous is returned. Runes remaining in the channel after
+// ResolveStream returns are left unread. Each rune advances the search from
+// where the previous rune left it, so the input is never searched again from
+// the root, except in a tree with a splitter, which can only divide the input
+// into tokens once it has been received.
+func (t *Tree[V]) ResolveStream(runes <-chan rune) (kv KeyValue[V], err error) {
+	if t.opts.isSplitting() {
+		var prefix []byte
+		for r := range runes {
+			prefix = utf8.AppendRune(prefix, r)
+			if st, err := t.findSubtree(string(prefix)); err != ErrPrefixAmbiguous {
+				return t.resolved(st, err)
+			}
 		}
+		return KeyValue[V]{}, ErrPrefixAmbiguous
+	}
 
-		// Perform the check on all candidate links.
-	innerLoop:
-		for i := start; i <= stop; i++ {
-			link := &t.links[i]
-			m := matchingChars(prefix, link.keyseg)
-			switch {
-			case m == 0:
-				continue innerLoop
-			case m == len(link.keyseg):
-				// Full link 1, so proceed down subtree.
-				t, prefix = link.tree, prefix[m:]
-				continue outerLoop
-			case m == len(prefix):
-				// Remaining prefix fully consumed, so return value data
-				// unless it's non-terminal.
-				switch {
-				case link.tree.descendants > 1:
-					return link.tree, ErrPrefixAmbiguous
-				case link.tree.isTerminal():
-					return link.tree, nil
-				default:
-					return nil, ErrPrefixNotFound
+	// The input has spelled the path to the node n, followed by the first
+	// off bytes of n's link ix, if ix isn't negative.
+	n, ix, off := t, -1, 0
+	for r := range runes {
+		for s := t.opts.fold(string(r)); len(s) > 0; {
+			if ix < 0 {
+				if ix = n.firstCharLink(t.opts.firstChar(s)); ix < 0 {
+					return KeyValue[V]{}, ErrPrefixNotFound
 				}
 			}
+			seg := n.links[ix].keyseg[off:]
+			m := matchingChars(s, seg)
+			if m < len(s) && m < len(seg) {
+				return KeyValue[V]{}, ErrPrefixNotFound
+			}
+			s, off = s[m:], off+m
+			if m == len(seg) {
+				n, ix, off = n.links[ix].tree, -1, 0
+			}
 		}
-		return nil, ErrPrefixNotFound
-	}
-}
 
-// matchingChars returns the number of shared characters in s1 and s2,
-// starting from the beginning of each string.
-func matchingChars(s1, s2 string) int {
-	i := 0
-	for l := min(len(s1), len(s2)); i < l; i++ {
-		if s1[i] != s2[i] {
-			break
+		// Input ending partway along a link matches the keys beneath it.
+		if ix >= 0 {
+			switch child := n.links[ix].tree; {
+			case child.descendants > 1:
+				continue
+			case child.isTerminal():
+				return KeyValue[V]{child.key, child.value}, nil
+			default:
+				return KeyValue[V]{}, ErrPrefixNotFound
+			}
+		}
+		if st, err := t.findSubtreeFrom(n, "", true); err != ErrPrefixAmbiguous {
+			return t.resolved(st, err)
 		}
 	}
-	return i
+	return KeyValue[V]{}, ErrPrefixAmbiguous
 }
 
-// appendDescendantKeys recursively appends a tree's descendant keys
-// to an array of keys.
-func appendDescendantKeys[V any](t *Tree[V], keys []string) []string {
-	if t.isTerminal() {
-		keys = append(keys, t.key)
-	}
-	for i := 0; i < len(t.links); i++ {
-		keys = appendDescendantKeys(t.links[i].tree, keys)
+// resolved returns the key and value of the subtree found by a search of
+// the prefix tree, or the error the search returned.
+func (t *Tree[V]) resolved(st *Tree[V], err error) (KeyValue[V], error) {
+	if err != nil {
+		return KeyValue[V]{}, err
 	}
-	return keys
+	return KeyValue[V]{st.key, st.value}, nil
 }
 
-// appendDescendantKeyValues recursively appends a tree's descendant keys
-// to an array of key/value pairs.
-func appendDescendantKeyValues[V any](t *Tree[V], kv []KeyValue[V]) []KeyValue[V] {
-	if t.isTerminal() {
-		kv = append(kv, KeyValue[V]{t.key, t.value})
-	}
-	for i := 0; i < len(t.links); i++ {
-		kv = appendDescendantKeyValues(t.links[i].tree, kv)
+// Get returns the value associated with a key that exactly matches a stored
+// key. Unlike FindValue, Get never treats the key as a prefix of longer keys,
+// so a key that isn't itself stored returns the zero value and false even if
+// it's an unambiguous prefix of a stored key.
+func (t *Tree[V]) Get(key string) (V, bool) {
+	if n := t.findExact(key); n != nil {
+		return n.value, true
 	}
-	return kv
+	var empty V
+	return empty, false
 }
 
-// appendDescendantValues recursively appends a tree's descendant values
-// to an array of values.
-func appendDescendantValues[V any](t *Tree[V], values []V) []V {
-	if t.isTerminal() {
-		values = append(values, t.value)
-	}
-	for i := 0; i < len(t.links); i++ {
-		values = appendDescendantValues(t.links[i].tree, values)
+// GetPtr returns a pointer to the value associated with a key that exactly
+// matches a stored key, so that the value may be modified in place without
+// being copied out of the tree and added back. If the key isn't stored,
+// GetPtr returns nil and false. The pointer remains valid until the key is
+// removed from the tree. Modifying a value through the pointer is a
+// modification of the tree, so it must not happen while another goroutine is
+// using the tree, and the WithConcurrencyCheck option can't detect it.
+func (t *Tree[V]) GetPtr(key string) (*V, bool) {
+	if n := t.findExact(key); n != nil {
+		return &n.value, true
 	}
-	return values
+	return nil, false
 }
 
-// Add a key string and its associated value data to the prefix tree.
-func (t *Tree[V]) Add(key string, value V) {
-	k := key
-outerLoop:
-	for {
-		t.descendants++
+// Contains returns true if the key exactly matches a stored key. Like Get,
+// Contains never treats the key as a prefix of longer keys, so a key that
+// isn't itself stored returns false even if it's a prefix of a stored key.
+func (t *Tree[V]) Contains(key string) bool {
+	return t.findExact(key) != nil
+}
+
+// LongestPrefix returns the longest stored key that is a prefix of s, along
+// with its value. This is the reverse of the Find methods, which look for
+// keys that s is a prefix of, and is useful for matching routes or paths
+// against a table of stored prefixes. If no stored key is a prefix of s,
+// LongestPrefix returns false.
+func (t *Tree[V]) LongestPrefix(s string) (key string, value V, ok bool) {
+	for k := t.opts.fold(s); ; {
+		if t.isTerminal() {
+			key, value, ok = t.key, t.value, true
+		}
+		if len(k) == 0 {
+			break
+		}
+		ix := t.prefixLink(k)
+		if ix < 0 {
+			break
+		}
+		link := &t.links[ix]
+		t, k = link.tree, k[len(link.keyseg):]
+	}
+	return key, value, ok
+}
+
+// HasPrefixOf returns true if any stored key is a prefix of s, as it would be
+// if LongestPrefix found a key. The search stops at the first, shortest key
+// found along s instead of continuing on to the longest, so it is cheaper
+// than LongestPrefix when it doesn't matter which key matched, as when s
+// need only be checked against a list of blocked prefixes.
+func (t *Tree[V]) HasPrefixOf(s string) bool {
+	for k := t.opts.fold(s); !t.isTerminal(); {
+		if len(k) == 0 {
+			return false
+		}
+		ix := t.prefixLink(k)
+		if ix < 0 {
+			return false
+		}
+		link := &t.links[ix]
+		t, k = link.tree, k[len(link.keyseg):]
+	}
+	return true
+}
+
+// FindNearest returns the stored key sharing the longest prefix with s,
+// along with its value, as a closest suggestion for a search that found no
+// match. The tree is descended for as long as its keys continue to match s.
+// When several keys share the longest prefix with s, the lexicographically
+// least of them is returned, so a stored key equal to s or to the shared
+// prefix is preferred over longer keys. FindNearest returns false only if
+// the tree is empty.
+func (t *Tree[V]) FindNearest(s string) (key string, value V, ok bool) {
+	n := t
+	for k := t.opts.fold(s); len(k) > 0; {
+		ix := n.firstCharLink(t.opts.firstChar(k))
+		if ix < 0 {
+			break
+		}
+		link := &n.links[ix]
+		m := matchingChars(k, link.keyseg)
+		n = link.tree
+		if m < len(link.keyseg) {
+			break
+		}
+		k = k[m:]
+	}
+	if n.descendants == 0 {
+		return "", value, false
+	}
+	d := firstDescendant(n)
+	return d.key, d.value, true
+}
+
+// ValuesForKeys looks up each of the provided keys, which must match stored
+// keys exactly rather than being prefixes of them. It returns the value
+// associated with each key and whether the key was found, both in the same
+// order as the keys. The value of a key that wasn't found is the zero value.
+func (t *Tree[V]) ValuesForKeys(keys []string) ([]V, []bool) {
+	values, found := make([]V, len(keys)), make([]bool, len(keys))
+	for i, key := range keys {
+		if n := t.findExact(key); n != nil {
+			values[i], found[i] = n.value, true
+		}
+	}
+	return values, found
+}
+
+// PrefixKeys returns all keys in the prefix tree that are proper prefixes of
+// at least one other key in the tree, such as "apple" in a tree that also
+// holds "applepie". These are the keys whose prefixes resolve to the key
+// itself under the TerminalWins mode but are ambiguous under the
+// TerminalAmbiguous mode. The keys are returned in lexicographic order.
+func (t *Tree[V]) PrefixKeys() []string {
+	keys := []string{}
+	walkDescendants(t, func(n *Tree[V]) bool {
+		if len(n.links) > 0 {
+			keys = append(keys, n.key)
+		}
+		return true
+	})
+	return keys
+}
+
+// KeysByLength returns all keys in the prefix tree that start with the
+// provided prefix, grouped by their length in bytes. The keys of each length
+// are in lexicographic order.
+func (t *Tree[V]) KeysByLength(prefix string) map[int][]string {
+	groups := make(map[int][]string)
+	if st, _ := t.prefixSubtree(prefix); st != nil {
+		walkDescendants(st, func(n *Tree[V]) bool {
+			groups[len(n.key)] = append(groups[len(n.key)], n.key)
+			return true
+		})
+	}
+	return groups
+}
+
+// Complete returns the keys in the prefix tree that start with the provided
+// prefix, in lexicographic order, along with the longest string all of them
+// start with, as shell tab completion does: with "commit" and "commitment"
+// stored, the prefix "com" completes to "commit". If no keys start with the
+// prefix, the prefix itself is returned with no keys. In a tree matching
+// keys in a transformed form, such as a case-folding tree, the keys are
+// compared as stored, and if they share less than the prefix, the prefix
+// itself is returned as the completion. The completion never ends partway
+// through a character, or partway through a token in a tree with a splitter.
+func (t *Tree[V]) Complete(prefix string) (completion string, keys []string) {
+	st, _ := t.prefixSubtree(prefix)
+	if st == nil {
+		return prefix, []string{}
+	}
+	keys = appendDescendantKeys(st, make([]string, 0, st.descendants))
+	completion = keys[0]
+	for _, key := range keys[1:] {
+		completion = completion[:matchingChars(completion, key)]
+	}
+	completion = completion[:t.opts.keyBoundary(keys[0], len(completion))]
+	if len(completion) < len(prefix) {
+		completion = prefix
+	}
+	return completion, keys
+}
+
+// NextChars returns the distinct characters that can follow the provided
+// prefix in the keys of the prefix tree, in ascending order, such as 'e' and
+// 'y' for the prefix "appl" in a tree holding "apple" and "apply". If no key
+// extends the prefix, including when the prefix is a stored key with no
+// longer keys beneath it, an empty slice is returned. In a case-folding tree
+// the characters are returned in their case-folded forms, and in a tree with
+// a splitter they are the first characters of the tokens that can follow the
+// prefix's tokens.
+func (t *Tree[V]) NextChars(prefix string) []rune {
+	chars := []rune{}
+	st, depth := t.prefixSubtree(prefix)
+	if st == nil {
+		return chars
+	}
+
+	// The subtree found may lie partway along a link, in which case the only
+	// character that can follow the prefix is found along the link, unless
+	// the link ends partway through the character.
+	pos := len(t.opts.fold(prefix))
+	if depth > pos {
+		path := t.opts.fold(firstDescendant(st).key)
+		if r, end, ok := t.opts.decodeRune(path, pos); ok && end <= depth {
+			return append(chars, r)
+		}
+	}
+	chars = appendNextChars(st, t.opts, depth, pos, chars)
+	slices.Sort(chars)
+	return slices.Compact(chars)
+}
+
+// CompleteAllowed returns the keys in the prefix tree that start with the
+// provided prefix and whose remaining characters, following the prefix, all
+// pass the allowed function. The walk of the matching subtree never descends
+// past a character that isn't allowed, so disallowed branches are skipped
+// entirely. The keys are returned in lexicographic order. In a case-folding
+// tree, allowed is passed the bytes of the keys' case-folded forms, both
+// along the link the prefix ends in and beneath it, and in a tree with a
+// splitter, it is passed only the bytes of the keys' tokens.
+func (t *Tree[V]) CompleteAllowed(prefix string, allowed func(next byte) bool) []string {
+	keys := []string{}
+	st, depth := t.prefixSubtree(prefix)
+	if st == nil {
+		return keys
+	}
+
+	// The subtree found may lie partway along a link, in which case the rest
+	// of the link's characters must be allowed too. They are read from the
+	// folded form of any key beneath the subtree, the same form the links
+	// beneath it hold.
+	escaped := false
+	if k := t.opts.fold(prefix); depth > len(k) {
+		path := t.opts.fold(firstDescendant(st).key)
+		var ok bool
+		if ok, escaped = allowedPath(t.opts, path[len(k):depth], false, allowed); !ok {
+			return keys
+		}
+	}
+	return appendAllowedKeys(st, t.opts, escaped, allowed, keys)
+}
+
+// FindTopN returns the n greatest keys and values in the prefix tree,
+// according to less, among the keys that start with the provided prefix. The
+// results are ordered greatest first. The matching keys are collected with a
+// bounded heap holding at most n of them, so they are never sorted as a
+// whole, making FindTopN much cheaper than sorting the results of
+// FindKeyValues when n is small. Entries that less considers equal are
+// returned in an unspecified order.
+func (t *Tree[V]) FindTopN(prefix string, n int, less func(a, b KeyValue[V]) bool) []KeyValue[V] {
+	st, _ := t.prefixSubtree(prefix)
+	if st == nil || n <= 0 {
+		return []KeyValue[V]{}
+	}
+	h := newTopN(n, less)
+	walkDescendants(st, func(node *Tree[V]) bool {
+		h.add(KeyValue[V]{node.key, node.value})
+		return true
+	})
+	return h.sorted()
+}
+
+// CountPrefix returns the number of keys in the prefix tree that start with
+// the provided prefix, including a key exactly matching the prefix. The
+// count is read from the matching subtree's count of descendant keys, so
+// CountPrefix takes time proportional to the length of the prefix rather
+// than to the number of matching keys. Every tree keeps these counts exact,
+// so there is no need to estimate them.
+func (t *Tree[V]) CountPrefix(prefix string) int {
+	st, _ := t.prefixSubtree(prefix)
+	if st == nil {
+		return 0
+	}
+	return st.descendants
+}
+
+// Summary returns the number of keys in the prefix tree that start with the
+// provided prefix, along with up to sampleN of those keys as examples. The
+// count is read from the matching subtree's count of descendant keys rather
+// than by enumerating them, and the samples are the lexicographically first
+// matching keys, so the cost of Summary is independent of the number of
+// matches.
+func (t *Tree[V]) Summary(prefix string, sampleN int) (count int, samples []string) {
+	samples = []string{}
+	st, _ := t.prefixSubtree(prefix)
+	if st == nil {
+		return 0, samples
+	}
+	if sampleN > 0 {
+		walkDescendants(st, func(n *Tree[V]) bool {
+			samples = append(samples, n.key)
+			return len(samples) < sampleN
+		})
+	}
+	return st.descendants, samples
+}
+
+// KeyAt returns the key and value found at the zero-based rank among all
+// keys in the prefix tree that start with the provided prefix, in
+// lexicographic order. Rather than enumerating the matching keys, KeyAt uses
+// each node's count of descendant keys to skip entire subtrees, so it takes
+// time proportional to the depth of the tree. If rank is out of range, KeyAt
+// returns false.
+func (t *Tree[V]) KeyAt(prefix string, rank int) (KeyValue[V], bool) {
+	st, _ := t.prefixSubtree(prefix)
+	if st == nil || rank < 0 || rank >= st.descendants {
+		return KeyValue[V]{}, false
+	}
+
+outerLoop:
+	for {
+		if st.isTerminal() {
+			if rank == 0 {
+				return KeyValue[V]{st.key, st.value}, true
+			}
+			rank--
+		}
+		for i := 0; i < len(st.links); i++ {
+			child := st.links[i].tree
+			if rank < child.descendants {
+				st = child
+				continue outerLoop
+			}
+			rank -= child.descendants
+		}
+		return KeyValue[V]{}, false
+	}
+}
+
+// Len returns the number of keys stored in the prefix tree. The count is
+// maintained as keys are added and removed, so Len takes constant time.
+func (t *Tree[V]) Len() int {
+	return t.descendants
+}
+
+// RootFanout returns the number of links leaving the root of the prefix
+// tree, which is the number of distinct leading characters (or, in a
+// compressed tree, leading key segments) among the stored keys. Nodes with at
+// least as many links as the tree's linear search cutoff, 20 unless set by
+// WithLinearSearchCutoff, are searched with a binary search instead of a
+// linear scan, so a fanout reaching the cutoff indicates that lookups from
+// the root are already taking the binary search path.
+func (t *Tree[V]) RootFanout() int {
+	return len(t.links)
+}
+
+// SampleKeys returns up to k keys chosen at random from all keys in the
+// prefix tree that start with the provided prefix. The keys are selected
+// using reservoir sampling during a single walk of the matching subtree, so
+// memory use is proportional to k rather than to the number of matches. If
+// fewer than k keys match, all of them are returned. The random choices are
+// drawn from rng, so a seeded rng produces reproducible samples; if rng is
+// nil, the math/rand package's default source is used. The returned keys are
+// sorted.
+func (t *Tree[V]) SampleKeys(prefix string, k int, rng *rand.Rand) []string {
+	st, _ := t.prefixSubtree(prefix)
+	if st == nil || k <= 0 {
+		return []string{}
+	}
+
+	intn := rand.Intn
+	if rng != nil {
+		intn = rng.Intn
+	}
+
+	sample, seen := make([]string, 0, k), 0
+	walkDescendants(st, func(n *Tree[V]) bool {
+		seen++
+		switch {
+		case len(sample) < k:
+			sample = append(sample, n.key)
+		default:
+			if i := intn(seen); i < k {
+				sample[i] = n.key
+			}
+		}
+		return true
+	})
+	sort.Strings(sample)
+	return sample
+}
+
+// AnyInRange returns true if at least one key in the prefix tree falls
+// within the inclusive range [lo, hi]. Subtrees whose keys all fall outside
+// the range are skipped, and the search stops at the first key found, so
+// this is much cheaper than enumerating the keys in the range.
+func (t *Tree[V]) AnyInRange(lo, hi string) bool {
+	lo, hi = t.opts.fold(lo), t.opts.fold(hi)
+	if lo > hi {
+		return false
+	}
+	return anyInRange(t, "", lo, hi)
+}
+
+// Min returns the lexicographically least key in the prefix tree and its
+// value, or false if the tree is empty.
+func (t *Tree[V]) Min() (key string, value V, ok bool) {
+	return t.MinPrefix("")
+}
+
+// Max returns the lexicographically greatest key in the prefix tree and its
+// value, or false if the tree is empty.
+func (t *Tree[V]) Max() (key string, value V, ok bool) {
+	return t.MaxPrefix("")
+}
+
+// MinPrefix returns the lexicographically least key in the prefix tree that
+// starts with the provided prefix, along with its value. It returns false if
+// no key starts with the prefix.
+func (t *Tree[V]) MinPrefix(prefix string) (key string, value V, ok bool) {
+	if st, _ := t.prefixSubtree(prefix); st != nil {
+		n := firstDescendant(st)
+		return n.key, n.value, true
+	}
+	return key, value, false
+}
+
+// MaxPrefix returns the lexicographically greatest key in the prefix tree
+// that starts with the provided prefix, along with its value. It returns
+// false if no key starts with the prefix.
+func (t *Tree[V]) MaxPrefix(prefix string) (key string, value V, ok bool) {
+	if st, _ := t.prefixSubtree(prefix); st != nil {
+		n := lastDescendant(st)
+		return n.key, n.value, true
+	}
+	return key, value, false
+}
+
+// Surround returns both the floor and the ceiling of key within the prefix
+// tree: the greatest stored key less than or equal to key, and the least
+// stored key greater than or equal to key. If key is itself stored, floor and
+// ceil are both key. Both bracketing keys are found during a single descent
+// of the tree. If no key is less than or equal to key, hasFloor is false; if
+// no key is greater than or equal to key, hasCeil is false.
+func (t *Tree[V]) Surround(key string) (floor, ceil KeyValue[V], hasFloor, hasCeil bool) {
+	// As the descent proceeds, each newly found candidate lies closer to key
+	// than the candidates found before it, so it replaces them.
+	var lo, hi *Tree[V]
+	o := t.opts
+	for k := o.fold(key); ; {
+		if len(k) == 0 {
+			if t.isTerminal() {
+				lo, hi = t, t
+			} else if len(t.links) > 0 {
+				hi = firstDescendant(t)
+			}
+			break
+		}
+		if t.isTerminal() {
+			lo = t
+		}
+
+		c := o.firstChar(k)
+		ix := sort.Search(len(t.links),
+			func(i int) bool { return t.links[i].keyseg >= c })
+		if ix > 0 {
+			lo = lastDescendant(t.links[ix-1].tree)
+		}
+		if ix == len(t.links) {
+			break
+		}
+
+		link := &t.links[ix]
+		if !strings.HasPrefix(link.keyseg, c) {
+			hi = firstDescendant(link.tree)
+			break
+		}
+		if ix+1 < len(t.links) {
+			hi = firstDescendant(t.links[ix+1].tree)
+		}
+
+		m := matchingChars(k, link.keyseg)
+		switch {
+		case m == len(link.keyseg):
+			t, k = link.tree, k[m:]
+			continue
+		case m == len(k) || link.keyseg[m] > k[m]:
+			hi = firstDescendant(link.tree)
+		default:
+			lo = lastDescendant(link.tree)
+		}
+		break
+	}
+
+	if lo != nil {
+		floor, hasFloor = KeyValue[V]{lo.key, lo.value}, true
+	}
+	if hi != nil {
+		ceil, hasCeil = KeyValue[V]{hi.key, hi.value}, true
+	}
+	return floor, ceil, hasFloor, hasCeil
+}
+
+// findSubtree searches the prefix tree for the deepest subtree matching
+// the prefix.
+func (t *Tree[V]) findSubtree(prefix string) (*Tree[V], error) {
+	prefix = t.opts.fold(prefix)
+	if t.opts.splitsRune(prefix) {
+		return nil, ErrPrefixNotFound
+	}
+	return t.findSubtreeFrom(t, prefix, len(prefix) > 0)
+}
+
+// findSubtreeFrom continues a search of the prefix tree for the deepest
+// subtree matching a folded prefix, starting from the node n reached by
+// following whole links spelling the prefix's leading bytes. The remaining
+// bytes of the prefix are passed in rest, and nonEmpty reports whether the
+// whole prefix is non-empty.
+func (t *Tree[V]) findSubtreeFrom(n *Tree[V], rest string, nonEmpty bool) (*Tree[V], error) {
+	// In an uncompressed tree, a non-empty prefix may end on a non-terminal
+	// node with a single descendant, which a compressed tree would have
+	// folded into a longer link.
+	uncompressed := t.opts.isUncompressed() && nonEmpty
+	terminalAmbiguous := t.opts.isTerminalAmbiguous()
+	cutoff := t.opts.linearSearchCutoff()
+
+	t, prefix := n, rest
+outerLoop:
+	for {
+		// Ran out of prefix?
+		if len(prefix) == 0 {
+			if t.isTerminal() && !(terminalAmbiguous && t.descendants > 1) {
+				return t, nil
+			}
+			if uncompressed && t.descendants == 1 {
+				for !t.isTerminal() {
+					t = t.links[0].tree
+				}
+				return t, nil
+			}
+			return t, ErrPrefixAmbiguous
+		}
+
+		// Figure out which links to consider. If the number of links from the
+		// node is large-ish (20+ by default), do a binary search for 2
+		// candidate links. Otherwise search all links. The default cutoff
+		// point between binary and linear search was determined by
+		// benchmarking against the unix english dictionary.
+		start, stop := 0, len(t.links)-1
+		if len(t.links) >= cutoff {
+			ix := sort.Search(len(t.links),
+				func(i int) bool { return t.links[i].keyseg >= prefix })
+			start, stop = max(0, ix-1), min(ix, stop)
+		}
+
+		// Perform the check on all candidate links.
+	innerLoop:
+		for i := start; i <= stop; i++ {
+			link := &t.links[i]
+			m := matchingChars(prefix, link.keyseg)
+			switch {
+			case m == 0:
+				continue innerLoop
+			case m == len(link.keyseg):
+				// Full link 1, so proceed down subtree.
+				t, prefix = link.tree, prefix[m:]
+				continue outerLoop
+			case m == len(prefix):
+				// Remaining prefix fully consumed, so return value data
+				// unless it's non-terminal.
+				switch {
+				case link.tree.descendants > 1:
+					return link.tree, ErrPrefixAmbiguous
+				case link.tree.isTerminal():
+					return link.tree, nil
+				default:
+					return nil, ErrPrefixNotFound
+				}
+			}
+		}
+		return nil, ErrPrefixNotFound
+	}
+}
+
+// findExact searches the prefix tree for the terminal node whose key exactly
+// matches key. It returns nil if the key isn't stored in the tree.
+func (t *Tree[V]) findExact(key string) *Tree[V] {
+	for k := t.opts.fold(key); len(k) > 0; {
+		ix := t.prefixLink(k)
+		if ix < 0 {
+			return nil
+		}
+		link := &t.links[ix]
+		t, k = link.tree, k[len(link.keyseg):]
+	}
+	if !t.isTerminal() {
+		return nil
+	}
+	return t
+}
+
+// prefixLink returns the index of the link whose key segment is a prefix of
+// k, or -1 if there is no such link.
+func (t *Tree[V]) prefixLink(k string) int {
+	// Links are sorted and begin with distinct characters, so the only link
+	// that can be a prefix of k is the last one ordered before it.
+	ix := sort.Search(len(t.links),
+		func(i int) bool { return t.links[i].keyseg > k })
+	if ix == 0 || !strings.HasPrefix(k, t.links[ix-1].keyseg) {
+		return -1
+	}
+	return ix - 1
+}
+
+// prefixSubtree returns the subtree containing every key in the prefix tree
+// that starts with the prefix, along with the length in bytes of the path
+// from the root to the subtree, which may extend past the end of the prefix.
+// It returns nil if no key starts with the prefix.
+func (t *Tree[V]) prefixSubtree(prefix string) (*Tree[V], int) {
+	prefix = t.opts.fold(prefix)
+	if t.opts.splitsRune(prefix) {
+		return nil, 0
+	}
+
+	o, depth := t.opts, 0
+	for k := prefix; len(k) > 0; {
+		ix := t.firstCharLink(o.firstChar(k))
+		if ix < 0 {
+			return nil, 0
+		}
+		link := &t.links[ix]
+		m := matchingChars(k, link.keyseg)
+		switch {
+		case m == len(link.keyseg):
+			t, k = link.tree, k[m:]
+			depth += m
+		case m == len(k):
+			return link.tree, depth + len(link.keyseg)
+		default:
+			return nil, 0
+		}
+	}
+	if t.descendants == 0 {
+		return nil, 0
+	}
+	return t, depth
+}
+
+// firstCharLink returns the index of the link whose key segment begins with
+// the character c, or -1 if there is no such link. The links of a node begin
+// with distinct characters: distinct bytes, or distinct runes in a tree
+// matching on rune boundaries.
+func (t *Tree[V]) firstCharLink(c string) int {
+	ix := sort.Search(len(t.links),
+		func(i int) bool { return t.links[i].keyseg >= c })
+	if ix == len(t.links) || !strings.HasPrefix(t.links[ix].keyseg, c) {
+		return -1
+	}
+	return ix
+}
+
+// matchingChars returns the number of shared characters in s1 and s2,
+// starting from the beginning of each string.
+func matchingChars(s1, s2 string) int {
+	i := 0
+	for l := min(len(s1), len(s2)); i < l; i++ {
+		if s1[i] != s2[i] {
+			break
+		}
+	}
+	return i
+}
+
+// appendDescendantKeys recursively appends a tree's descendant keys
+// to an array of keys.
+func appendDescendantKeys[V any](t *Tree[V], keys []string) []string {
+	if t.isTerminal() {
+		keys = append(keys, t.key)
+	}
+	for i := 0; i < len(t.links); i++ {
+		keys = appendDescendantKeys(t.links[i].tree, keys)
+	}
+	return keys
+}
+
+// appendDescendantKeysDesc recursively appends a tree's descendant keys to
+// an array of keys in descending order. Each link is followed from last to
+// first, and a terminal key is appended after the longer keys beneath it.
+func appendDescendantKeysDesc[V any](t *Tree[V], keys []string) []string {
+	for i := len(t.links) - 1; i >= 0; i-- {
+		keys = appendDescendantKeysDesc(t.links[i].tree, keys)
+	}
+	if t.isTerminal() {
+		keys = append(keys, t.key)
+	}
+	return keys
+}
+
+// appendDescendantKeyValues recursively appends a tree's descendant keys
+// to an array of key/value pairs.
+func appendDescendantKeyValues[V any](t *Tree[V], kv []KeyValue[V]) []KeyValue[V] {
+	if t.isTerminal() {
+		kv = append(kv, KeyValue[V]{t.key, t.value})
+	}
+	for i := 0; i < len(t.links); i++ {
+		kv = appendDescendantKeyValues(t.links[i].tree, kv)
+	}
+	return kv
+}
+
+// appendDescendantValues recursively appends a tree's descendant values
+// to an array of values.
+func appendDescendantValues[V any](t *Tree[V], values []V) []V {
+	if t.isTerminal() {
+		values = append(values, t.value)
+	}
+	for i := 0; i < len(t.links); i++ {
+		values = appendDescendantValues(t.links[i].tree, values)
+	}
+	return values
+}
+
+// appendNextChars recursively appends to chars the characters beginning at
+// byte offset pos in the keys beneath a subtree found at the given depth in
+// bytes. A link may end before the character does, such as partway through a
+// multibyte character, in which case the links beneath it complete the
+// character in different ways.
+func appendNextChars[V any](t *Tree[V], o *options, depth, pos int, chars []rune) []rune {
+	for i := 0; i < len(t.links); i++ {
+		l := &t.links[i]
+		path := o.fold(firstDescendant(l.tree).key)
+		r, end, ok := o.decodeRune(path, pos)
+		if !ok {
+			continue
+		}
+		if next := depth + len(l.keyseg); end > next {
+			chars = appendNextChars(l.tree, o, next, pos, chars)
+		} else {
+			chars = append(chars, r)
+		}
+	}
+	return chars
+}
+
+// appendAllowedKeys recursively appends to keys the terminal descendants of
+// a tree reachable through links made up entirely of allowed characters. If
+// escaped is true, the path to the tree ends partway through an escape
+// sequence.
+func appendAllowedKeys[V any](t *Tree[V], o *options, escaped bool, allowed func(next byte) bool, keys []string) []string {
+	if t.isTerminal() {
+		keys = append(keys, t.key)
+	}
+	for i := 0; i < len(t.links); i++ {
+		if ok, esc := allowedPath(o, t.links[i].keyseg, escaped, allowed); ok {
+			keys = appendAllowedKeys(t.links[i].tree, o, esc, allowed, keys)
+		}
+	}
+	return keys
+}
+
+// allowedPath returns true if allowed accepts every key byte encoded in s,
+// a piece of a folded path following an escape byte if escaped is true, and
+// reports whether s itself ends with an escape byte. In a tree with a
+// splitter, the zero bytes ending tokens are skipped and escaped bytes are
+// decoded before being passed to allowed.
+func allowedPath(o *options, s string, escaped bool, allowed func(next byte) bool) (ok, escape bool) {
+	splitting := o.isSplitting()
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if splitting {
+			var isByte bool
+			if c, isByte, escaped = decodeByte(c, escaped); !isByte {
+				continue
+			}
+		}
+		if !allowed(c) {
+			return false, false
+		}
+	}
+	return true, escaped
+}
+
+// firstDescendant returns the terminal descendant of a non-empty tree having
+// the lexicographically least key.
+func firstDescendant[V any](t *Tree[V]) *Tree[V] {
+	for !t.isTerminal() {
+		t = t.links[0].tree
+	}
+	return t
+}
+
+// lastDescendant returns the terminal descendant of a non-empty tree having
+// the lexicographically greatest key.
+func lastDescendant[V any](t *Tree[V]) *Tree[V] {
+	for len(t.links) > 0 {
+		t = t.links[len(t.links)-1].tree
+	}
+	return t
+}
+
+// walkDescendants recursively calls fn for each of a tree's terminal
+// descendants in lexicographic key order. The walk stops as soon as fn returns
+// false, in which case walkDescendants also returns false.
+func walkDescendants[V any](t *Tree[V], fn func(n *Tree[V]) bool) bool {
+	if t.isTerminal() && !fn(t) {
+		return false
+	}
+	for i := 0; i < len(t.links); i++ {
+		if !walkDescendants(t.links[i].tree, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// walkDescendantsAfter calls fn, in order, for each terminal descendant of a
+// tree whose key is greater than after, stopping early and returning false if
+// fn returns false. The path from the root to the tree must be after[:depth].
+// Links ordered entirely before after are skipped without being descended.
+func walkDescendantsAfter[V any](t *Tree[V], after string, depth int, fn func(n *Tree[V]) bool) bool {
+	// The tree's own key, if any, is a prefix of after and so isn't greater.
+	r := after[depth:]
+	for i := 0; i < len(t.links); i++ {
+		seg := t.links[i].keyseg
+		n := min(len(seg), len(r))
+		switch c := strings.Compare(seg[:n], r[:n]); {
+		case c < 0:
+			continue
+		case c == 0 && n == len(seg):
+			if !walkDescendantsAfter(t.links[i].tree, after, depth+n, fn) {
+				return false
+			}
+		default:
+			if !walkDescendants(t.links[i].tree, fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// anyInRange recursively searches a tree, all of whose keys start with path,
+// for a key within the inclusive range [lo, hi].
+func anyInRange[V any](t *Tree[V], path, lo, hi string) bool {
+	// Every key in the subtree is at least path. And if path is less than lo
+	// without being a prefix of it, then so is every key in the subtree.
+	if path > hi || (path < lo && !strings.HasPrefix(lo, path)) {
+		return false
+	}
+	if t.isTerminal() && path >= lo {
+		return true
+	}
+	for i := 0; i < len(t.links); i++ {
+		if anyInRange(t.links[i].tree, path+t.links[i].keyseg, lo, hi) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a key string and its associated value data to the prefix tree. The
+// empty string can't be stored as a key, since it would belong to the root
+// of the tree, which holds no key, so adding it has no effect.
+func (t *Tree[V]) Add(key string, value V) {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	t.add(key, value)
+}
+
+// AddReport adds a key string and its associated value data to the prefix
+// tree, exactly as Add does, and returns true if the key was newly added or
+// false if it was already stored and its value was replaced. Like Add, it
+// ignores the empty key, returning false.
+func (t *Tree[V]) AddReport(key string, value V) (created bool) {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	_, created = t.insert(key, value, true)
+	return created
+}
+
+// add adds a key string and its associated value data to the prefix tree
+// without checking for concurrent use.
+func (t *Tree[V]) add(key string, value V) {
+	t.insert(key, value, true)
+}
+
+// insert adds a key string and its associated value data to the prefix tree
+// without checking for concurrent use, returning the key's terminal node and
+// whether the key was newly added. If the key was already stored, its value
+// is replaced only if replace is true. A key whose path through the tree is
+// empty, such as the empty key, would belong to the root, so it isn't added
+// and insert returns a nil node.
+func (t *Tree[V]) insert(key string, value V, replace bool) (n *Tree[V], added bool) {
+	path := t.opts.fold(key)
+	if path == "" {
+		return nil, false
+	}
+	root := t
+	if t.opts.isUncompressed() {
+		return t.insertUncompressed(key, path, value, replace)
+	}
+
+	k := path
+outerLoop:
+	for {
+		t.descendants++
 
 		// If we've consumed the entire string, then the tree node is terminal
 		// and we're done.
 		if len(k) == 0 {
-			t.key, t.value = key, value
-			break outerLoop
+			return t.store(root, path, key, value, replace)
 		}
 
 		// Find the lexicographical link insertion point.
@@ -269,9 +1638,12 @@ outerLoop:
 				t, k = link.tree, k[m:]
 				continue outerLoop
 			case m > 0:
-				// Partial match, so we'll need to split this tree node.
-				splitLink, splitIndex = link, m
-				break innerLoop
+				// Partial match, so we'll need to split this tree node,
+				// without dividing a character.
+				if m = root.opts.charBoundary(link.keyseg, m); m > 0 {
+					splitLink, splitIndex = link, m
+					break innerLoop
+				}
 			}
 		}
 
@@ -285,7 +1657,7 @@ outerLoop:
 			}
 			t.links = append(t.links[:ix],
 				append([]link[V]{{k, child}}, t.links[ix:]...)...)
-			break outerLoop
+			return child, true
 		}
 
 		// A split is necessary, so split the current link's string and insert
@@ -303,18 +1675,715 @@ outerLoop:
 	}
 }
 
-// Output the structure of the tree to stdout. This function exists for
-// debugging purposes.
+// AddIfAbsent adds a key string and its associated value data to the prefix
+// tree only if the key isn't already stored in it. It returns the value
+// stored with the key after the call, and whether the value provided was
+// added. If the key was already stored, its existing value is returned and
+// left unchanged. The tree is only descended once. Like Add, it ignores the
+// empty key, returning the zero value and false.
+func (t *Tree[V]) AddIfAbsent(key string, value V) (stored V, added bool) {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	n, added := t.insert(key, value, false)
+	if n == nil {
+		var empty V
+		return empty, false
+	}
+	return n.value, added
+}
+
+// Update replaces the value associated with a stored key by the value fn
+// returns when called with the current value, descending the tree only once.
+// The key must exactly match a stored key; if it doesn't, fn isn't called and
+// Update returns false.
+func (t *Tree[V]) Update(key string, fn func(old V) V) bool {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	n := t.findExact(key)
+	if n == nil {
+		return false
+	}
+	n.value = fn(n.value)
+	return true
+}
+
+// AddAll adds each key and value in kvs to the prefix tree, in order, as if
+// by calling Add for each of them.
+func (t *Tree[V]) AddAll(kvs []KeyValue[V]) {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	for _, kv := range kvs {
+		t.add(kv.Key, kv.Value)
+	}
+}
+
+// AddSorted adds each key and value in kvs to the prefix tree, in order, as
+// if by calling Add for each of them. It is optimized for keys that are
+// sorted in increasing lexicographic order and greater than or equal to
+// every key already in the tree, as when building a tree from a sorted list:
+// each such key is added along the rightmost path of the tree, where it only
+// has to be compared with the last link of each node and its new link is
+// appended rather than inserted. Keys that break the order are still added
+// correctly, but without the benefit of the optimization.
+func (t *Tree[V]) AddSorted(kvs []KeyValue[V]) {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	last := ""
+	if t.descendants > 0 {
+		last = t.opts.fold(lastDescendant(t).key)
+	}
+	for _, kv := range kvs {
+		path := t.opts.fold(kv.Key)
+		if path < last || path == "" {
+			t.add(kv.Key, kv.Value)
+			continue
+		}
+		t.addLast(kv.Key, path, kv.Value)
+		last = path
+	}
+}
+
+// addLast adds a key string and its associated value data to the prefix
+// tree, where path is the form of the key used to match it and is greater
+// than or equal to the paths of all keys already in the tree.
+func (t *Tree[V]) addLast(key, path string, value V) {
+	root, k := t, path
+	for {
+		t.descendants++
+
+		// If we've consumed the entire string, then the tree node is terminal
+		// and we're done.
+		if len(k) == 0 {
+			t.store(root, path, key, value, true)
+			return
+		}
+
+		// Only the last link can share a prefix with the key. Follow it if
+		// the key runs through it, or split it if the key diverges from it.
+		if n := len(t.links); n > 0 {
+			l := &t.links[n-1]
+			m := root.opts.charBoundary(l.keyseg, matchingChars(l.keyseg, k))
+			switch {
+			case m == len(l.keyseg):
+				t, k = l.tree, k[m:]
+				continue
+			case m > 0:
+				child := &Tree[V]{
+					key:         "",
+					links:       []link[V]{{l.keyseg[m:], l.tree}},
+					descendants: l.tree.descendants,
+				}
+				l.keyseg, l.tree = l.keyseg[:m], child
+				t, k = child, k[m:]
+				continue
+			}
+		}
+
+		// Otherwise the key follows every existing link, so append a new
+		// link for it. An uncompressed tree gets one new node per character.
+		if root.opts.isUncompressed() {
+			n := root.opts.charLen(k)
+			t.links = append(t.links, link[V]{k[:n], new(Tree[V])})
+			t, k = t.links[len(t.links)-1].tree, k[n:]
+			continue
+		}
+		child := &Tree[V]{
+			key:         key,
+			value:       value,
+			links:       nil,
+			descendants: 1,
+		}
+		t.links = append(t.links, link[V]{k, child})
+		return
+	}
+}
+
+// ApplyDelta applies a batch of changes to the prefix tree: each key in
+// upserts is added with its associated value, replacing any existing value,
+// and each key in deletes is removed. A key present in both upserts and
+// deletes is upserted. ApplyDelta returns the number of keys that were newly
+// added, the number whose values were replaced, and the number removed.
+// Deleted keys that weren't in the tree are ignored. Keys are matched the
+// way the tree matches them, so in a case-folding tree an upsert of "apple"
+// also overrides a delete of "APPLE". Upserts are applied in the order of
+// their matched forms, and upserts of keys matching alike in lexicographic
+// order, so the last of those keys supplies the value the tree keeps,
+// however the map happens to be ordered.
+func (t *Tree[V]) ApplyDelta(upserts map[string]V, deletes []string) (added, updated, removed int) {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	type upsert struct{ path, key string }
+	order := make([]upsert, 0, len(upserts))
+	paths := make(map[string]bool, len(upserts))
+	for key := range upserts {
+		path := t.opts.fold(key)
+		order = append(order, upsert{path, key})
+		paths[path] = true
+	}
+	slices.SortFunc(order, func(a, b upsert) int {
+		if c := strings.Compare(a.path, b.path); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key, b.key)
+	})
+
+	for _, key := range deletes {
+		if !paths[t.opts.fold(key)] && t.remove(key) {
+			removed++
+		}
+	}
+	for _, u := range order {
+		switch n, ok := t.insert(u.key, upserts[u.key], true); {
+		case ok:
+			added++
+		case n != nil:
+			updated++
+		}
+	}
+	return added, updated, removed
+}
+
+// Merge adds all keys and values of other to the prefix tree. When a key is
+// stored in both trees, the value from other replaces the tree's value, as
+// if each of other's keys had been added with Add. The other tree is left
+// unchanged. When the trees' keys are known not to share first characters,
+// MergeDisjoint is much faster.
+func (t *Tree[V]) Merge(other *Tree[V]) {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	walkDescendants(other, func(n *Tree[V]) bool {
+		t.add(n.key, n.value)
+		return true
+	})
+}
+
+// MergeDisjoint merges all keys and values of other into the prefix tree,
+// provided that no key in other begins with the same character as a key in
+// the tree. This is the case, for instance, when the trees were built from
+// shards of a keyspace partitioned by first character. Because the merged
+// keys can't collide, the root links of other are moved into the tree
+// wholesale rather than adding each key individually, so the merge takes
+// time proportional to the number of root links. Only keys whose first
+// characters share leading bytes with the tree's, such as "é" and "è", are
+// added individually. MergeDisjoint takes ownership of other's nodes and
+// leaves other empty. If the keyspaces overlap, ErrKeyspaceOverlap is
+// returned and neither tree is modified.
+func (t *Tree[V]) MergeDisjoint(other *Tree[V]) error {
+	t.opts.acquire()
+	defer t.opts.release()
+	if other != t {
+		other.opts.acquire()
+		defer other.opts.release()
+	}
+
+	// Subtrees can only be moved between trees sharing a representation.
+	movable := t.opts.isUncompressed() == other.opts.isUncompressed() &&
+		t.opts.isCaseFolding() == other.opts.isCaseFolding() &&
+		t.opts.isRuneAligned() == other.opts.isRuneAligned() &&
+		!t.opts.isSplitting() && !other.opts.isSplitting()
+
+	used := make(map[string]bool, len(t.links))
+	firstChars(t, "", func(c string) bool {
+		used[c] = true
+		return true
+	})
+	overlap := false
+	if movable {
+		firstChars(other, "", func(c string) bool {
+			overlap = used[c]
+			return !overlap
+		})
+	} else {
+		walkDescendants(other, func(n *Tree[V]) bool {
+			path := t.opts.fold(n.key)
+			_, size := utf8.DecodeRuneInString(path)
+			overlap = used[path[:size]]
+			return !overlap
+		})
+	}
+	if overlap {
+		return ErrKeyspaceOverlap
+	}
+
+	if !movable {
+		walkDescendants(other, func(n *Tree[V]) bool {
+			t.add(n.key, n.value)
+			return true
+		})
+	} else {
+		var moved []link[V]
+		for _, l := range other.links {
+			if t.firstCharLink(t.opts.firstChar(l.keyseg)) < 0 {
+				moved = append(moved, l)
+				t.descendants += l.tree.descendants
+				continue
+			}
+
+			// The link's first byte begins a different character than the
+			// tree's link sharing that byte, so its keys join that link.
+			walkDescendants(l.tree, func(n *Tree[V]) bool {
+				t.add(n.key, n.value)
+				return true
+			})
+		}
+		if len(moved) > 0 {
+			t.links = append(t.links, moved...)
+			sort.Slice(t.links, func(i, j int) bool {
+				return t.links[i].keyseg < t.links[j].keyseg
+			})
+		}
+	}
+
+	other.links, other.descendants = nil, 0
+	return nil
+}
+
+// firstChars calls fn with the first UTF-8 encoded character of the keys
+// beneath each link of the subtree n, whose path from the root is prefix,
+// until fn returns false. Bytes that aren't part of a valid UTF-8 sequence
+// are characters of their own.
+func firstChars[V any](n *Tree[V], prefix string, fn func(c string) bool) bool {
+	for _, l := range n.links {
+		path := prefix + l.keyseg
+		if !utf8.FullRuneInString(path) {
+			if (l.tree.isTerminal() && !fn(path[:1])) || !firstChars(l.tree, path, fn) {
+				return false
+			}
+			continue
+		}
+		if _, size := utf8.DecodeRuneInString(path); !fn(path[:size]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete removes a key and its associated value from the prefix tree,
+// returning false if the key isn't stored in it. Nodes that no longer lead to
+// any key are pruned, and links split when the key was added are merged
+// again, so the tree keeps the shape it would have had if the key had never
+// been added, and never needs compacting after many deletions.
+func (t *Tree[V]) Delete(key string) bool {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	return t.remove(key)
+}
+
+// A pathStep records a link followed while descending a prefix tree: the
+// node the link leaves and the link's index within it.
+type pathStep[V any] struct {
+	tree *Tree[V]
+	ix   int
+}
+
+// remove removes a key from the prefix tree, returning false if the key isn't
+// stored in it. Nodes left without keys beneath them are pruned, and in a
+// compressed tree a non-terminal node left with a single link is merged into
+// the link leading to it, so the tree keeps the shape it would have had if
+// the key had never been added.
+func (t *Tree[V]) remove(key string) bool {
+	// Find the key's terminal node, recording the links followed to reach
+	// it.
+	var path []pathStep[V]
+	n := t
+	for k := t.opts.fold(key); len(k) > 0; {
+		ix := n.prefixLink(k)
+		if ix < 0 {
+			return false
+		}
+		path = append(path, pathStep[V]{n, ix})
+		k = k[len(n.links[ix].keyseg):]
+		n = n.links[ix].tree
+	}
+	if !n.isTerminal() {
+		return false
+	}
+
+	for _, s := range path {
+		s.tree.descendants--
+	}
+	var empty V
+	n.key, n.value = "", empty
+	n.descendants--
+	t.prune(path, n)
+	return true
+}
+
+// DeletePrefix removes every key starting with the provided prefix from the
+// prefix tree, including a key exactly matching the prefix, and returns the
+// number of keys removed. The subtree holding the keys is detached in one
+// step rather than removing its keys one at a time, and the rest of the tree
+// is left with the shape it would have had if the keys had never been added.
+func (t *Tree[V]) DeletePrefix(prefix string) int {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	prefix = t.opts.fold(prefix)
+	if t.opts.splitsRune(prefix) {
+		return 0
+	}
+
+	// Find the subtree holding the keys, recording the links followed to
+	// reach it. The prefix may end partway along the last link.
+	var path []pathStep[V]
+	n := t
+	for k := prefix; len(k) > 0; {
+		ix := n.firstCharLink(t.opts.firstChar(k))
+		if ix < 0 {
+			return 0
+		}
+		link := &n.links[ix]
+		m := matchingChars(k, link.keyseg)
+		if m < len(link.keyseg) && m < len(k) {
+			return 0
+		}
+		path = append(path, pathStep[V]{n, ix})
+		k = k[min(m, len(k)):]
+		n = link.tree
+	}
+
+	count := n.descendants
+	if count == 0 {
+		return 0
+	}
+	if len(path) == 0 {
+		t.reset()
+		return count
+	}
+
+	for _, s := range path {
+		s.tree.descendants -= count
+	}
+	var empty V
+	n.key, n.value, n.links, n.descendants = "", empty, nil, 0
+	t.prune(path, n)
+	return count
+}
+
+// prune removes nodes left without keys beneath them at the end of a path
+// followed from the root of the prefix tree to the node n. In a compressed
+// tree, a non-terminal node left with a single link is then merged into the
+// link leading to it.
+func (t *Tree[V]) prune(path []pathStep[V], n *Tree[V]) {
+	// Prune nodes that no longer lead to any key.
+	d := len(path)
+	for d > 0 && len(n.links) == 0 && !n.isTerminal() {
+		d--
+		parent := path[d].tree
+		parent.links = slices.Delete(parent.links, path[d].ix, path[d].ix+1)
+		n = parent
+	}
+
+	// Merge a non-terminal node left with a single link into its parent's
+	// link. The merged key segment is cut from a key beneath it, so it
+	// shares that key's memory.
+	if d > 0 && len(n.links) == 1 && !n.isTerminal() && !t.opts.isUncompressed() {
+		offset := 0
+		for _, s := range path[:d-1] {
+			offset += len(s.tree.links[s.ix].keyseg)
+		}
+		l := &path[d-1].tree.links[path[d-1].ix]
+		child := n.links[0].tree
+		last := child
+		for !last.isTerminal() {
+			last = last.links[0].tree
+		}
+		l.keyseg = t.opts.fold(last.key)[offset : offset+len(l.keyseg)+len(n.links[0].keyseg)]
+		l.tree = child
+	}
+}
+
+// store makes the node reached by following path from root the terminal node
+// of a key being added, returning the node and whether the key was newly
+// added. If the key was already stored, store undoes the counts incremented
+// on the way down, and replaces the key's value only if replace is true,
+// replacing its stored form too unless the tree keeps first-added forms.
+func (t *Tree[V]) store(root *Tree[V], path, key string, value V, replace bool) (*Tree[V], bool) {
+	added := !t.isTerminal()
+	if !added {
+		root.uncount(path)
+	}
+	if added || replace {
+		t.value = value
+		if added || !root.opts.keepsFirstCasing() {
+			t.key = key
+		}
+	}
+	return t, added
+}
+
+// uncount decrements the count of descendant keys of every node on the path
+// to a stored key. Add calls it when it finds that the key it is adding was
+// already stored, to undo the counts it incremented on the way down.
+func (t *Tree[V]) uncount(path string) {
+	for k := path; ; {
+		t.descendants--
+		if len(k) == 0 {
+			return
+		}
+		link := &t.links[t.prefixLink(k)]
+		t, k = link.tree, k[len(link.keyseg):]
+	}
+}
+
+// insertUncompressed adds a key string and its associated value data to an
+// uncompressed prefix tree, where every link holds a single character of
+// key's non-empty path. It returns the same results as insert.
+func (t *Tree[V]) insertUncompressed(key, path string, value V, replace bool) (n *Tree[V], added bool) {
+	root := t
+	for k := path; ; {
+		t.descendants++
+
+		// If we've consumed the entire string, then the tree node is terminal
+		// and we're done.
+		if len(k) == 0 {
+			return t.store(root, path, key, value, replace)
+		}
+
+		// Find the link for the next character, inserting a new link and
+		// subtree if there isn't one yet.
+		ks := root.opts.firstChar(k)
+		ix := sort.Search(len(t.links),
+			func(i int) bool { return t.links[i].keyseg >= ks })
+		if ix == len(t.links) || t.links[ix].keyseg != ks {
+			t.links = append(t.links[:ix],
+				append([]link[V]{{ks, new(Tree[V])}}, t.links[ix:]...)...)
+		}
+		t, k = t.links[ix].tree, k[len(ks):]
+	}
+}
+
+// Equal returns true if the prefix tree and other hold the same keys, and eq
+// reports the values associated with each key in the two trees as equal.
+// Only the stored keys and values are compared, so trees built by adding the
+// same keys in different orders or with different options are equal even
+// though their internal structure may differ.
+func (t *Tree[V]) Equal(other *Tree[V], eq func(a, b V) bool) bool {
+	if t.descendants != other.descendants {
+		return false
+	}
+	return walkDescendants(other, func(o *Tree[V]) bool {
+		n := t.findExact(o.key)
+		return n != nil && n.key == o.key && eq(n.value, o.value)
+	})
+}
+
+// DiffKeys compares the keys of the prefix tree with the keys of other,
+// returning the keys found only in the prefix tree and the keys found only
+// in other, each in lexicographic order. Keys are matched the way the prefix
+// tree matches them, so in a case-folding tree keys differing only in case
+// are the same key. The keys of the two trees are merged in a single ordered
+// walk of each, which requires other to order its keys as the prefix tree
+// does, as trees created with the same options do.
+func (t *Tree[V]) DiffKeys(other *Tree[V]) (onlyT, onlyOther []string) {
+	onlyT, onlyOther = []string{}, []string{}
+	t.mergeKeys(other, func(key string, inT, inOther bool) {
+		switch {
+		case !inOther:
+			onlyT = append(onlyT, key)
+		case !inT:
+			onlyOther = append(onlyOther, key)
+		}
+	})
+	return onlyT, onlyOther
+}
+
+// IntersectKeys returns the keys found both in the prefix tree and in other,
+// in lexicographic order. Keys are matched and merged as they are by
+// DiffKeys, and a key stored in the two trees in different forms is returned
+// in the form stored in the prefix tree.
+func (t *Tree[V]) IntersectKeys(other *Tree[V]) []string {
+	keys := []string{}
+	t.mergeKeys(other, func(key string, inT, inOther bool) {
+		if inT && inOther {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// mergeKeys steps through the keys of the prefix tree and other together in
+// lexicographic order, calling fn for each distinct key with the trees it is
+// stored in. A key stored in both trees is passed in the prefix tree's form.
+func (t *Tree[V]) mergeKeys(other *Tree[V], fn func(key string, inT, inOther bool)) {
+	a, b := t.Cursor(), other.Cursor()
+	for {
+		ka, okA := a.Peek()
+		kb, okB := b.Peek()
+		if !okA && !okB {
+			return
+		}
+		fa, fb := t.opts.fold(ka.Key), t.opts.fold(kb.Key)
+		switch {
+		case !okB || (okA && fa < fb):
+			fn(ka.Key, true, false)
+			a.Next()
+		case !okA || fb < fa:
+			fn(kb.Key, false, true)
+			b.Next()
+		default:
+			fn(ka.Key, true, true)
+			a.Next()
+			b.Next()
+		}
+	}
+}
+
+// Clear removes every key from the prefix tree, leaving it empty but with
+// its configuration intact. The root's link slice is kept, so refilling the
+// tree after clearing it allocates less than building a new tree.
+func (t *Tree[V]) Clear() {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	t.reset()
+}
+
+// reset removes every key from the prefix tree, keeping the capacity of the
+// root's link slice.
+func (t *Tree[V]) reset() {
+	clear(t.links)
+	t.links, t.descendants = t.links[:0], 0
+}
+
+// Clone returns a deep copy of the prefix tree. The copy has its own nodes
+// and links, so keys may be added to or removed from either tree without
+// affecting the other. Values are copied by assignment, so a value holding a
+// pointer is shared by both trees. The copy has the same configuration as
+// the original, though if metrics are enabled, its metrics start from zero.
+func (t *Tree[V]) Clone() *Tree[V] {
+	c := cloneTree(t)
+	c.opts = t.opts.clone()
+	return c
+}
+
+// cloneTree recursively copies a tree's nodes and links.
+func cloneTree[V any](t *Tree[V]) *Tree[V] {
+	c := &Tree[V]{
+		key:         t.key,
+		value:       t.value,
+		links:       nil,
+		descendants: t.descendants,
+	}
+	if len(t.links) > 0 {
+		c.links = make([]link[V], len(t.links))
+		for i := 0; i < len(t.links); i++ {
+			c.links[i] = link[V]{t.links[i].keyseg, cloneTree(t.links[i].tree)}
+		}
+	}
+	return c
+}
+
+// RemapValues replaces the value associated with every key in the prefix
+// tree with the value returned by fn. The keys and the structure of the tree
+// are left untouched, so this is cheaper than rebuilding the tree when only
+// its values change.
+func (t *Tree[V]) RemapValues(fn func(key string, old V) V) {
+	t.opts.acquire()
+	defer t.opts.release()
+
+	remapValues(t, fn)
+}
+
+// remapValues recursively replaces the values of a tree's terminal
+// descendants.
+func remapValues[V any](t *Tree[V], fn func(key string, old V) V) {
+	if t.isTerminal() {
+		t.value = fn(t.key, t.value)
+	}
+	for i := 0; i < len(t.links); i++ {
+		remapValues(t.links[i].tree, fn)
+	}
+}
+
+// Output the structure of the tree to stdout. In a tree with a splitter, the
+// end of each token within a key segment is shown as a vertical bar. This
+// function exists for debugging purposes.
 func (t *Tree[V]) Output() {
-	t.outputNode(0)
+	t.Fprint(os.Stdout)
+}
+
+// Fprint writes the structure of the tree in the format written by Output
+// to w. This function exists for debugging purposes.
+func (t *Tree[V]) Fprint(w io.Writer) {
+	t.Fprintf(w, formatValue[V])
 }
 
-func (t *Tree[V]) outputNode(level int) {
-	fmt.Printf("%sNode: key=\"%s\" term=%v desc=%d value=%v\n",
-		strings.Repeat("    ", level), t.key, t.isTerminal(), t.descendants, t.value)
+// Fprintf writes the structure of the tree in the format written by Output
+// to w, using the format function to render each node's value. The function
+// also receives the node's key, which is empty for nodes that don't hold
+// keys. This function exists for debugging purposes.
+func (t *Tree[V]) Fprintf(w io.Writer, format func(key string, value V) string) {
+	t.outputNode(w, t.opts, 0, false, format)
+}
+
+// String returns the structure of the tree in the format written by Output.
+// This function exists for debugging purposes.
+func (t *Tree[V]) String() string {
+	var b strings.Builder
+	t.outputNode(&b, t.opts, 0, false, formatValue[V])
+	return b.String()
+}
+
+// StringFunc returns the structure of the tree in the format written by
+// Output, using the format function to render each node's value. This makes
+// the structure legible for value types that the %v verb formats poorly.
+func (t *Tree[V]) StringFunc(format func(V) string) string {
+	var b strings.Builder
+	t.outputNode(&b, t.opts, 0, false, func(key string, value V) string { return format(value) })
+	return b.String()
+}
+
+// formatValue formats a node's value using the %v verb, ignoring its key.
+func formatValue[V any](key string, v V) string {
+	return fmt.Sprintf("%v", v)
+}
+
+func (t *Tree[V]) outputNode(w io.Writer, o *options, level int, escaped bool, format func(key string, value V) string) {
+	fmt.Fprintf(w, "%sNode: key=\"%s\" term=%v desc=%d value=%s\n",
+		strings.Repeat("    ", level), t.key, t.isTerminal(), t.descendants, format(t.key, t.value))
 	for i, l := range t.links {
-		fmt.Printf("%s  Link %d: ks=\"%s\"\n",
-			strings.Repeat("    ", level), i, l.keyseg)
-		l.tree.outputNode(level + 1)
+		label, esc := o.segmentLabel(l.keyseg, escaped)
+		fmt.Fprintf(w, "%s  Link %d: ks=\"%s\"\n",
+			strings.Repeat("    ", level), i, label)
+		l.tree.outputNode(w, o, level+1, esc, format)
+	}
+}
+
+// WriteDOT writes a Graphviz DOT description of the structure of the tree to
+// w, suitable for rendering with a command such as "dot -Tpng". Each node of
+// the tree is a vertex, and each link is an edge labeled with its key
+// segment, shown as Output shows it. Terminal nodes, which carry values, are
+// drawn as double circles labeled with their keys and values. This function
+// exists for debugging purposes.
+func (t *Tree[V]) WriteDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph prefixtree {")
+	id := 0
+	t.writeDOTNode(w, t.opts, false, &id)
+	fmt.Fprintln(w, "}")
+}
+
+// writeDOTNode writes the DOT description of a node and its descendants,
+// numbering the nodes in depth-first order starting with *id.
+func (t *Tree[V]) writeDOTNode(w io.Writer, o *options, escaped bool, id *int) {
+	n := *id
+	*id++
+	if t.isTerminal() {
+		fmt.Fprintf(w, "\tn%d [shape=doublecircle label=%q];\n",
+			n, t.key+"\n"+formatValue(t.key, t.value))
+	} else {
+		fmt.Fprintf(w, "\tn%d [shape=circle label=\"\"];\n", n)
+	}
+	for _, l := range t.links {
+		label, esc := o.segmentLabel(l.keyseg, escaped)
+		fmt.Fprintf(w, "\tn%d -> n%d [label=%q];\n", n, *id, label)
+		l.tree.writeDOTNode(w, o, esc, id)
 	}
 }
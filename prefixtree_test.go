@@ -6,9 +6,16 @@ package prefixtree
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"fmt"
 	"math/rand"
 	"os"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 type entry struct {
@@ -22,6 +29,10 @@ type testcase struct {
 	err   error
 }
 
+// representations holds the options selecting each of the ways a prefix tree
+// can store its keys, for tests that must pass however the keys are stored.
+var representations = [][]Option{nil, {WithoutCompression()}}
+
 func test(t *testing.T, entries []entry, cases []testcase) *Tree[int] {
 	// Run 256 iterations of build/find using random tree entry
 	// insertion orders.
@@ -179,6 +190,41 @@ func TestLargeDegree(t *testing.T) {
 		})
 }
 
+func TestUncompressed(t *testing.T) {
+	// An uncompressed tree must resolve every prefix as a compressed tree
+	// holding the same keys does, whatever order the keys are added in.
+	keys := []string{"apple", "applepie", "a", "armor", "abc", "ab", "-dog"}
+	for c := 'a'; c <= 'z'; c++ {
+		keys = append(keys, "-"+string(c))
+	}
+	prefixes := []string{"", "b", "p", "pple"}
+	for _, key := range keys {
+		for i := 1; i <= len(key); i++ {
+			prefixes = append(prefixes, key[:i])
+		}
+		prefixes = append(prefixes, key+"s")
+	}
+
+	for i := 0; i < 64; i++ {
+		compressed, uncompressed := New[int](), New[int](WithoutCompression())
+		for _, j := range rand.Perm(len(keys)) {
+			compressed.Add(keys[j], j)
+			uncompressed.Add(keys[j], j)
+		}
+		for _, prefix := range prefixes {
+			v1, err1 := uncompressed.FindValue(prefix)
+			v2, err2 := compressed.FindValue(prefix)
+			if v1 != v2 || err1 != err2 {
+				t.Errorf("Iteration %d: FindValue(\"%s\") returned (%d, %v), expected (%d, %v).\n",
+					i, prefix, v1, err1, v2, err2)
+			}
+		}
+		if t.Failed() {
+			break
+		}
+	}
+}
+
 func TestFindKeys(t *testing.T) {
 	entries := []entry{
 		{"apple", 1},
@@ -241,6 +287,547 @@ func TestFindKeys(t *testing.T) {
 	}
 }
 
+func TestFindKeyOrCandidates(t *testing.T) {
+	cases := []struct {
+		prefix     string
+		key        string
+		candidates []string
+		err        error
+	}{
+		{"", "", []string{"apple", "applepie", "arm", "bee", "bog"}, ErrPrefixAmbiguous},
+		{"a", "", []string{"apple", "applepie", "arm"}, ErrPrefixAmbiguous},
+		{"ap", "", []string{"apple", "applepie"}, ErrPrefixAmbiguous},
+		{"apple", "apple", nil, nil},
+		{"applep", "applepie", nil, nil},
+		{"ar", "arm", nil, nil},
+		{"b", "", []string{"bee", "bog"}, ErrPrefixAmbiguous},
+		{"bo", "bog", nil, nil},
+		{"c", "", nil, ErrPrefixNotFound},
+		{"armor", "", nil, ErrPrefixNotFound},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			key, candidates, err := tree.FindKeyOrCandidates(c.prefix)
+			if key != c.key || !slices.Equal(candidates, c.candidates) || err != c.err {
+				t.Errorf("Case %d: FindKeyOrCandidates(\"%s\") returned (\"%s\", %v, %v), expected (\"%s\", %v, %v).\n",
+					i, c.prefix, key, candidates, err, c.key, c.candidates, c.err)
+			}
+		}
+	}
+}
+
+func TestFindKeyPreferExact(t *testing.T) {
+	cases := []struct {
+		prefix string
+		key    string
+		err    error
+	}{
+		{"a", "", ErrPrefixAmbiguous},
+		{"ad", "", ErrPrefixAmbiguous},
+		{"add", "add", nil},
+		{"addr", "address", nil},
+		{"co", "", ErrPrefixAmbiguous},
+		{"com", "commit", nil},
+		{"x", "", ErrPrefixNotFound},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"add", "address", "commit", "config"} {
+			tree.Add(key, i)
+		}
+		for _, mode := range []TerminalPrefixMode{TerminalWins, TerminalAmbiguous} {
+			tree.SetTerminalPrefixMode(mode)
+			for i, c := range cases {
+				if key, err := tree.FindKeyPreferExact(c.prefix); key != c.key || err != c.err {
+					t.Errorf("Case %d: FindKeyPreferExact(\"%s\") returned (\"%s\", %v) in mode %d, expected (\"%s\", %v).\n",
+						i, c.prefix, key, err, mode, c.key, c.err)
+				}
+			}
+		}
+	}
+}
+
+func TestFindKeyValueExactness(t *testing.T) {
+	cases := []struct {
+		prefix string
+		key    string
+		exact  bool
+		err    error
+	}{
+		{"a", "", false, ErrPrefixAmbiguous},
+		{"ad", "", false, ErrPrefixAmbiguous},
+		{"add", "add", true, nil},
+		{"addr", "address", false, nil},
+		{"address", "address", true, nil},
+		{"addresses", "", false, ErrPrefixNotFound},
+		{"c", "commit", false, nil},
+		{"commit", "commit", true, nil},
+		{"Commit", "", false, ErrPrefixNotFound},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"add", "address", "commit"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			kv, exact, err := tree.FindKeyValueExactness(c.prefix)
+			if kv.Key != c.key || exact != c.exact || err != c.err {
+				t.Errorf("Case %d: FindKeyValueExactness(\"%s\") returned (%v, %v, %v), expected (\"%s\", %v, %v).\n",
+					i, c.prefix, kv, exact, err, c.key, c.exact, c.err)
+			}
+		}
+	}
+
+	// A case-folding tree matches the key exactly regardless of case.
+	tree := New[int](WithCaseFolding())
+	tree.Add("Commit", 1)
+	tree.Add("Checkout", 2)
+	for _, prefix := range []string{"commit", "COMMIT", "Commit"} {
+		if kv, exact, err := tree.FindKeyValueExactness(prefix); kv.Key != "Commit" || !exact || err != nil {
+			t.Errorf("FindKeyValueExactness(\"%s\") returned (%v, %v, %v), expected exact match.\n",
+				prefix, kv, exact, err)
+		}
+	}
+	if _, exact, _ := tree.FindKeyValueExactness("comm"); exact {
+		t.Errorf("FindKeyValueExactness(\"comm\") reported an exact match.\n")
+	}
+}
+
+func TestFindUpTo(t *testing.T) {
+	cases := []struct {
+		prefix string
+		limit  int
+		keys   []string
+		err    error
+	}{
+		{"", 5, []string{"apple", "applepie", "arm", "bee", "bog"}, nil},
+		{"", 4, nil, ErrPrefixAmbiguous},
+		{"a", 3, []string{"apple", "applepie", "arm"}, nil},
+		{"a", 2, nil, ErrPrefixAmbiguous},
+		{"ap", 2, []string{"apple", "applepie"}, nil},
+		{"ap", 1, nil, ErrPrefixAmbiguous},
+		{"ap", 0, nil, ErrPrefixAmbiguous},
+		{"apple", 1, []string{"apple"}, nil},
+		{"apple", 0, []string{"apple"}, nil},
+		{"ar", 1, []string{"arm"}, nil},
+		{"b", 10, []string{"bee", "bog"}, nil},
+		{"c", 10, nil, ErrPrefixNotFound},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		if _, err := tree.FindUpTo("", 10); err != ErrPrefixNotFound {
+			t.Errorf("FindUpTo on an empty tree returned error %v, expected %v.\n", err, ErrPrefixNotFound)
+		}
+		for i, key := range []string{"apple", "applepie", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			kvs, err := tree.FindUpTo(c.prefix, c.limit)
+			var keys []string
+			for _, kv := range kvs {
+				keys = append(keys, kv.Key)
+				if v, _ := tree.Get(kv.Key); kv.Value != v {
+					t.Errorf("Case %d: FindUpTo returned value %d for key %q, expected %d.\n", i, kv.Value, kv.Key, v)
+				}
+			}
+			if !slices.Equal(keys, c.keys) || err != c.err {
+				t.Errorf("Case %d: FindUpTo(\"%s\", %d) returned (%v, %v), expected (%v, %v).\n",
+					i, c.prefix, c.limit, keys, err, c.keys, c.err)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	cases := []struct {
+		prefix string
+		key    string
+	}{
+		{"", ""},
+		{"a", ""},
+		{"apple", "apple"},
+		{"applep", "applepie"},
+		{"ar", "arm"},
+		{"c", ""},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		keys := []string{"apple", "applepie", "arm", "bee"}
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			kv, ok := tree.Lookup(c.prefix)
+			if kv.Key != c.key || ok != (c.key != "") || (ok && keys[kv.Value] != kv.Key) {
+				t.Errorf("Case %d: Lookup(\"%s\") returned (%v, %v), expected \"%s\".\n",
+					i, c.prefix, kv, ok, c.key)
+			}
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		prefix string
+		state  MatchState
+	}{
+		{"", MatchAmbiguous},
+		{"a", MatchAmbiguous},
+		{"ap", MatchAmbiguous},
+		{"apple", MatchUnique},
+		{"applep", MatchUnique},
+		{"applepies", MatchNotFound},
+		{"ar", MatchUnique},
+		{"b", MatchUnique},
+		{"c", MatchNotFound},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			if state := tree.Match(c.prefix); state != c.state {
+				t.Errorf("Case %d: Match(\"%s\") returned %d, expected %d.\n",
+					i, c.prefix, state, c.state)
+			}
+			if a := tree.IsAmbiguous(c.prefix); a != (c.state == MatchAmbiguous) {
+				t.Errorf("Case %d: IsAmbiguous(\"%s\") returned %v.\n", i, c.prefix, a)
+			}
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		prefix     string
+		kv         KeyValue[int]
+		candidates []KeyValue[int]
+		state      MatchState
+	}{
+		{"", KeyValue[int]{}, []KeyValue[int]{{"apple", 0}, {"applepie", 1}, {"arm", 2}, {"bee", 3}, {"bog", 4}}, MatchAmbiguous},
+		{"a", KeyValue[int]{}, []KeyValue[int]{{"apple", 0}, {"applepie", 1}, {"arm", 2}}, MatchAmbiguous},
+		{"ap", KeyValue[int]{}, []KeyValue[int]{{"apple", 0}, {"applepie", 1}}, MatchAmbiguous},
+		{"apple", KeyValue[int]{"apple", 0}, nil, MatchUnique},
+		{"applep", KeyValue[int]{"applepie", 1}, nil, MatchUnique},
+		{"ar", KeyValue[int]{"arm", 2}, nil, MatchUnique},
+		{"b", KeyValue[int]{}, []KeyValue[int]{{"bee", 3}, {"bog", 4}}, MatchAmbiguous},
+		{"c", KeyValue[int]{}, nil, MatchNotFound},
+		{"armor", KeyValue[int]{}, nil, MatchNotFound},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			kv, candidates, state := tree.Resolve(c.prefix)
+			if kv != c.kv || !slices.Equal(candidates, c.candidates) || state != c.state {
+				t.Errorf("Case %d: Resolve(\"%s\") returned (%v, %v, %d), expected (%v, %v, %d).\n",
+					i, c.prefix, kv, candidates, state, c.kv, c.candidates, c.state)
+			}
+			if m := tree.Match(c.prefix); m != state {
+				t.Errorf("Case %d: Resolve(\"%s\") returned state %d, but Match returned %d.\n",
+					i, c.prefix, state, m)
+			}
+		}
+	}
+}
+
+func TestPrefixExists(t *testing.T) {
+	cases := []struct {
+		prefix string
+		exists bool
+	}{
+		{"", true},
+		{"a", true},
+		{"ap", true},
+		{"apple", true},
+		{"applep", true},
+		{"applepies", false},
+		{"arm", true},
+		{"armor", false},
+		{"b", true},
+		{"c", false},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		if tree.PrefixExists("") {
+			t.Errorf("PrefixExists(\"\") returned true for an empty tree.\n")
+		}
+		for i, key := range []string{"apple", "applepie", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			if exists := tree.PrefixExists(c.prefix); exists != c.exists {
+				t.Errorf("Case %d: PrefixExists(\"%s\") returned %v, expected %v.\n",
+					i, c.prefix, exists, c.exists)
+			}
+		}
+	}
+}
+
+func TestFindKeysLimit(t *testing.T) {
+	cases := []struct {
+		prefix string
+		limit  int
+		keys   []string
+	}{
+		{"", 0, []string{"a", "apple", "applepie", "arm", "bee", "bog"}},
+		{"", -1, []string{"a", "apple", "applepie", "arm", "bee", "bog"}},
+		{"", 1, []string{"a"}},
+		{"", 3, []string{"a", "apple", "applepie"}},
+		{"", 10, []string{"a", "apple", "applepie", "arm", "bee", "bog"}},
+		{"a", 2, []string{"a"}},
+		{"ap", 1, []string{"apple"}},
+		{"ap", 2, []string{"apple", "applepie"}},
+		{"apple", 1, []string{"apple"}},
+		{"b", 1, []string{"bee"}},
+		{"c", 1, []string{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "a", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			if keys := tree.FindKeysLimit(c.prefix, c.limit); !slices.Equal(keys, c.keys) {
+				t.Errorf("Case %d: FindKeysLimit(\"%s\", %d) returned %v, expected %v.\n",
+					i, c.prefix, c.limit, keys, c.keys)
+			}
+		}
+	}
+}
+
+func TestFindKeysDesc(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for _, i := range rand.Perm(len(keys)) {
+			tree.Add(keys[i], i)
+		}
+		for _, prefix := range []string{"", "a", "ap", "appl", "apple", "applepie", "ar", "b", "c"} {
+			expected := tree.FindKeys(prefix)
+			slices.Reverse(expected)
+			if keys := tree.FindKeysDesc(prefix); !slices.Equal(keys, expected) {
+				t.Errorf("FindKeysDesc(\"%s\") returned %v, expected %v.\n", prefix, keys, expected)
+			}
+		}
+	}
+}
+
+func TestFindKeysPage(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+
+	cases := []struct {
+		prefix string
+		after  string
+		limit  int
+		keys   []string
+		next   string
+	}{
+		{"", "", 3, []string{"a", "apple", "applepie"}, "applepie"},
+		{"", "applepie", 3, []string{"apricot", "arm", "armor"}, "armor"},
+		{"", "armor", 3, []string{"bee", "bog"}, ""},
+		{"", "", 8, keys, ""},
+		{"", "", 0, keys, ""},
+		{"", "apple", 0, []string{"applepie", "apricot", "arm", "armor", "bee", "bog"}, ""},
+		{"", "appl", 2, []string{"apple", "applepie"}, "applepie"},
+		{"", "applez", 1, []string{"apricot"}, "apricot"},
+		{"", "b", 1, []string{"bee"}, "bee"},
+		{"", "bog", 1, []string{}, ""},
+		{"", "c", 1, []string{}, ""},
+		{"ap", "", 2, []string{"apple", "applepie"}, "applepie"},
+		{"ap", "applepie", 2, []string{"apricot"}, ""},
+		{"ap", "aa", 5, []string{"apple", "applepie", "apricot"}, ""},
+		{"ap", "b", 5, []string{}, ""},
+		{"appl", "apple", 5, []string{"applepie"}, ""},
+		{"apple", "", 5, []string{"apple"}, ""},
+		{"apple", "apple", 5, []string{}, ""},
+		{"c", "", 5, []string{}, ""},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for _, i := range rand.Perm(len(keys)) {
+			tree.Add(keys[i], i)
+		}
+		for i, c := range cases {
+			keys, next := tree.FindKeysPage(c.prefix, c.after, c.limit)
+			if !slices.Equal(keys, c.keys) || next != c.next {
+				t.Errorf("Case %d: FindKeysPage(\"%s\", \"%s\", %d) returned (%v, \"%s\"), expected (%v, \"%s\").\n",
+					i, c.prefix, c.after, c.limit, keys, next, c.keys, c.next)
+			}
+		}
+
+		// Paging through the keys matching a prefix returns the same keys
+		// as FindKeys.
+		for _, prefix := range []string{"", "a", "ap", "ar", "b"} {
+			for limit := 1; limit <= 3; limit++ {
+				var all []string
+				for page, after := 0, ""; page < len(keys); page++ {
+					keys, next := tree.FindKeysPage(prefix, after, limit)
+					all = append(all, keys...)
+					if next == "" {
+						break
+					}
+					after = next
+				}
+				if expected := tree.FindKeys(prefix); !slices.Equal(all, expected) {
+					t.Errorf("Paging \"%s\" by %d returned %v, expected %v.\n", prefix, limit, all, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestFindValuesBatch(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+	prefixes := []string{
+		"appl", "", "a", "apple", "ap", "applepie", "applepies", "bo", "b",
+		"apple", "c", "ar", "armo", "armor", "app", "apz", "bee", "be",
+	}
+
+	optSets := [][]Option{nil, {WithoutCompression()}, {WithCaseFolding()}}
+	for _, opts := range optSets {
+		for _, mode := range []TerminalPrefixMode{TerminalWins, TerminalAmbiguous} {
+			tree := New[int](opts...)
+			tree.SetTerminalPrefixMode(mode)
+			for _, i := range rand.Perm(len(keys)) {
+				tree.Add(keys[i], i)
+			}
+
+			results := tree.FindValuesBatch(prefixes)
+			if len(results) != len(prefixes) {
+				t.Fatalf("FindValuesBatch returned %d results, expected %d.\n", len(results), len(prefixes))
+			}
+			for i, prefix := range prefixes {
+				value, err := tree.FindValue(prefix)
+				if results[i].Value != value || results[i].Err != err {
+					t.Errorf("Case %d: FindValuesBatch result for \"%s\" is (%d, %v), expected (%d, %v).\n",
+						i, prefix, results[i].Value, results[i].Err, value, err)
+				}
+			}
+		}
+	}
+
+	// Batches of many overlapping prefixes share most of their walks.
+	big := New[int]()
+	bigKeys := benchmarkKeys(2000)
+	for i, key := range bigKeys {
+		big.Add(key, i)
+	}
+	prefixes = prefixes[:0]
+	for i := 0; i < 2000; i++ {
+		key := bigKeys[rand.Intn(len(bigKeys))]
+		prefixes = append(prefixes, key[:rand.Intn(len(key)+1)])
+	}
+	for i, r := range big.FindValuesBatch(prefixes) {
+		if value, err := big.FindValue(prefixes[i]); r.Value != value || r.Err != err {
+			t.Errorf("FindValuesBatch result for \"%s\" is (%d, %v), expected (%d, %v).\n",
+				prefixes[i], r.Value, r.Err, value, err)
+		}
+	}
+
+	if results := New[int]().FindValuesBatch(nil); len(results) != 0 {
+		t.Errorf("FindValuesBatch(nil) returned %v.\n", results)
+	}
+}
+
+func TestFindKeyValuesFunc(t *testing.T) {
+	even := func(kv KeyValue[int]) bool { return kv.Value%2 == 0 }
+
+	cases := []struct {
+		prefix string
+		keys   []string
+	}{
+		{"", []string{"apple", "arm", "bog"}},
+		{"a", []string{"apple", "arm"}},
+		{"ap", []string{"apple"}},
+		{"apple", []string{"apple"}},
+		{"applep", []string{}},
+		{"b", []string{"bog"}},
+		{"be", []string{}},
+		{"c", []string{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			var keys []string
+			for _, kv := range tree.FindKeyValuesFunc(c.prefix, even) {
+				keys = append(keys, kv.Key)
+			}
+			if !slices.Equal(keys, c.keys) {
+				t.Errorf("Case %d: FindKeyValuesFunc(\"%s\") returned %v, expected %v.\n",
+					i, c.prefix, keys, c.keys)
+			}
+		}
+	}
+}
+
+// countdownContext is a context that becomes canceled once its Err method
+// has been called a given number of times.
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining--; c.remaining < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestFindKeyValuesContext(t *testing.T) {
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range benchmarkKeys(5000) {
+			tree.Add(key, i)
+		}
+
+		for _, prefix := range []string{"", "a", "b", "ba", "zzzzz"} {
+			kvs, err := tree.FindKeyValuesContext(context.Background(), prefix)
+			if expected := tree.FindKeyValues(prefix); !slices.Equal(kvs, expected) || err != nil {
+				t.Errorf("FindKeyValuesContext(\"%s\") returned %d keys (%v), expected %d.\n",
+					prefix, len(kvs), err, len(expected))
+			}
+		}
+
+		// A canceled context stops the search before it starts.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if kvs, err := tree.FindKeyValuesContext(ctx, ""); kvs != nil || err != context.Canceled {
+			t.Errorf("FindKeyValuesContext with a canceled context returned (%d keys, %v).\n", len(kvs), err)
+		}
+
+		// A context canceled during the search stops it at the next check.
+		ctx = &countdownContext{context.Background(), 2}
+		if kvs, err := tree.FindKeyValuesContext(ctx, ""); kvs != nil || err != context.Canceled {
+			t.Errorf("FindKeyValuesContext with a context canceled mid-search returned (%d keys, %v).\n",
+				len(kvs), err)
+		}
+	}
+}
+
 func TestFindValues(t *testing.T) {
 	entries := []entry{
 		{"apple", 1},
@@ -299,45 +886,1904 @@ func TestFindValues(t *testing.T) {
 	}
 }
 
-func TestMatchingChars(t *testing.T) {
-	type test struct {
-		s1     string
-		s2     string
-		result int
-	}
-	var cases = []test{
-		{"a", "ap", 1},
-		{"ap", "ap", 2},
-		{"app", "ap", 2},
-		{"apple", "ap", 2},
-		{"ap", "a", 1},
-		{"apple", "a", 1},
-		{"apple", "bag", 0},
-	}
-	for i, c := range cases {
-		r := matchingChars(c.s1, c.s2)
-		if r != c.result {
-			t.Errorf("Case %d: matchingChars(\"%s\", \"%s\") returned %d, expected %d\n",
-				i, c.s1, c.s2, r, c.result)
+func TestAppendFind(t *testing.T) {
+	prefixes := []string{"", "a", "ap", "apple", "applep", "applepies", "ar", "b", "c"}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"a", "apple", "applepie", "arm", "bee"} {
+			tree.Add(key, i)
+		}
+		for i, prefix := range prefixes {
+			// Each result is appended after what dst already holds.
+			keys := tree.AppendKeys([]string{"x"}, prefix)
+			if expected := append([]string{"x"}, tree.FindKeys(prefix)...); !slices.Equal(keys, expected) {
+				t.Errorf("Case %d: AppendKeys(\"%s\") returned %v, expected %v.\n", i, prefix, keys, expected)
+			}
+			kvs := tree.AppendKeyValues([]KeyValue[int]{{"x", -1}}, prefix)
+			if expected := append([]KeyValue[int]{{"x", -1}}, tree.FindKeyValues(prefix)...); !slices.Equal(kvs, expected) {
+				t.Errorf("Case %d: AppendKeyValues(\"%s\") returned %v, expected %v.\n", i, prefix, kvs, expected)
+			}
+			values := tree.AppendValues([]int{-1}, prefix)
+			if expected := append([]int{-1}, tree.FindValues(prefix)...); !slices.Equal(values, expected) {
+				t.Errorf("Case %d: AppendValues(\"%s\") returned %v, expected %v.\n", i, prefix, values, expected)
+			}
+		}
+
+		// Reusing a slice with enough capacity doesn't allocate.
+		keys := tree.AppendKeys(nil, "")
+		kvs := tree.AppendKeyValues(nil, "")
+		values := tree.AppendValues(nil, "")
+		allocs := testing.AllocsPerRun(100, func() {
+			for _, prefix := range prefixes {
+				keys = tree.AppendKeys(keys[:0], prefix)
+				kvs = tree.AppendKeyValues(kvs[:0], prefix)
+				values = tree.AppendValues(values[:0], prefix)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("Appending to reused slices made %v allocations, expected 0.\n", allocs)
 		}
 	}
 }
 
-func TestDictionary(t *testing.T) {
-	// Attempt to open the unix words dictionary file. If it doesn't
-	// exist, skip this test.
-	file, err := os.Open("/usr/share/dict/words")
-	if err != nil {
-		return
+func TestKeysValues(t *testing.T) {
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		if keys, values := tree.Keys(), tree.Values(); keys == nil || len(keys) != 0 || values == nil || len(values) != 0 {
+			t.Errorf("Empty tree returned keys %v and values %v, expected empty slices.\n", keys, values)
+		}
+
+		for _, i := range []int{3, 0, 4, 1, 2} {
+			tree.Add([]string{"apple", "applepie", "arm", "bee", "bog"}[i], i)
+		}
+		expectedKeys := []string{"apple", "applepie", "arm", "bee", "bog"}
+		if keys := tree.Keys(); !slices.Equal(keys, expectedKeys) {
+			t.Errorf("Keys() returned %v, expected %v.\n", keys, expectedKeys)
+		}
+		expectedValues := []int{0, 1, 2, 3, 4}
+		if values := tree.Values(); !slices.Equal(values, expectedValues) {
+			t.Errorf("Values() returned %v, expected %v.\n", values, expectedValues)
+		}
 	}
+}
 
-	// Scan all words from the dictionary into the tree.
-	tree := New[bool]()
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		tree.Add(scanner.Text(), true)
+func TestSortedKeys(t *testing.T) {
+	keys := []string{"Apple", "a", "applepie", "Arm", "bee", "a.b.c", "a.bc", "a-z"}
+	dots := func(s string) []string { return strings.Split(s, ".") }
+
+	for _, opts := range [][]Option{nil, {WithoutCompression()}, {WithCaseFolding()}, {WithSplitter(dots)}} {
+		tree := New[int](opts...)
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		expected := tree.Keys()
+		slices.Sort(expected)
+		sorted := tree.SortedKeys()
+		if !slices.Equal(sorted, expected) {
+			t.Errorf("SortedKeys returned %q, expected %q.\n", sorted, expected)
+		}
+		for i, kv := range tree.SortedKeyValues() {
+			if v, _ := tree.Get(kv.Key); i >= len(expected) || kv.Key != expected[i] || kv.Value != v {
+				t.Errorf("SortedKeyValues returned %v at index %d.\n", kv, i)
+			}
+		}
+		for _, key := range sorted {
+			if i := sort.SearchStrings(sorted, key); sorted[i] != key {
+				t.Errorf("Binary search of SortedKeys failed to find %q.\n", key)
+			}
+		}
 	}
-	file.Close()
+}
+
+func TestRemapValues(t *testing.T) {
+	entries := []entry{
+		{"apple", 1},
+		{"applepie", 2},
+		{"a", 3},
+		{"arm", 4},
+		{"bee", 5},
+	}
+
+	tree := New[int]()
+	for _, entry := range entries {
+		tree.Add(entry.key, entry.value)
+	}
+
+	prefixes := []string{"", "a", "ap", "apple", "applep", "ar", "b", "c"}
+	before := make([][]string, len(prefixes))
+	for i, p := range prefixes {
+		before[i] = tree.FindKeys(p)
+	}
+
+	tree.RemapValues(func(key string, old int) int {
+		return old*10 + len(key)
+	})
+
+	for i, p := range prefixes {
+		after := tree.FindKeys(p)
+		if len(after) != len(before[i]) {
+			t.Errorf("Case %d: FindKeys(\"%s\") returned %v, expected %v.\n",
+				i, p, after, before[i])
+			continue
+		}
+		for j := range after {
+			if after[j] != before[i][j] {
+				t.Errorf("Case %d: FindKeys(\"%s\") returned %v, expected %v.\n",
+					i, p, after, before[i])
+				break
+			}
+		}
+	}
+
+	for i, e := range entries {
+		value, err := tree.FindValue(e.key)
+		expected := e.value*10 + len(e.key)
+		if err != nil || value != expected {
+			t.Errorf("Case %d: FindValue(\"%s\") returned %d (%v), expected %d.\n",
+				i, e.key, value, err, expected)
+		}
+	}
+}
+
+func TestResolveStream(t *testing.T) {
+	cases := []struct {
+		input  string
+		key    string
+		err    error
+		unread int
+	}{
+		{"", "", ErrPrefixAmbiguous, 0},
+		{"a", "", ErrPrefixAmbiguous, 0},
+		{"armor", "arm", nil, 3},
+		{"apple", "apple", nil, 0},
+		{"applep", "apple", nil, 1},
+		{"ax", "", ErrPrefixNotFound, 0},
+		{"beetle", "bee", nil, 5},
+		{"c", "", ErrPrefixNotFound, 0},
+		{"日", "", ErrPrefixAmbiguous, 0},
+		{"日本語", "日本", nil, 1},
+		{"月曜", "月曜", nil, 1},
+	}
+
+	stream := func(input string) chan rune {
+		runes := make(chan rune, len(input))
+		for _, r := range input {
+			runes <- r
+		}
+		close(runes)
+		return runes
+	}
+
+	for _, opts := range [][]Option{nil, {WithoutCompression()}, {WithCaseFolding()}, {WithRuneBoundaries()}, {WithSplitter(SplitRunes)}} {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee", "日本", "日曜", "月曜"} {
+			tree.Add(key, i)
+		}
+
+		for i, c := range cases {
+			runes := stream(c.input)
+			kv, err := tree.ResolveStream(runes)
+			if err != c.err || kv.Key != c.key {
+				t.Errorf("Case %d: ResolveStream(\"%s\") returned (%q, %v), expected (%q, %v).\n",
+					i, c.input, kv.Key, err, c.key, c.err)
+			}
+			if len(runes) != c.unread {
+				t.Errorf("Case %d: ResolveStream(\"%s\") left %d runes unread, expected %d.\n",
+					i, c.input, len(runes), c.unread)
+			}
+		}
+
+		// The input resolves as soon as FindKeyValue resolves its prefix.
+		for _, key := range tree.Keys() {
+			for j := range key {
+				input := key[:j] + "x"
+				expected, err := KeyValue[int]{}, ErrPrefixAmbiguous
+				for k := range input {
+					_, size := utf8.DecodeRuneInString(input[k:])
+					if expected, err = tree.FindKeyValue(input[:k+size]); err != ErrPrefixAmbiguous {
+						break
+					}
+				}
+				if kv, err2 := tree.ResolveStream(stream(input)); kv != expected || err2 != err {
+					t.Errorf("ResolveStream(%q) returned (%v, %v), expected (%v, %v).\n",
+						input, kv, err2, expected, err)
+				}
+			}
+		}
+	}
+}
+
+// checkCounts verifies that every node's count of descendant keys matches the
+// number of terminal nodes beneath it, and returns the count.
+func checkCounts(t *testing.T, tree *Tree[int]) int {
+	n := 0
+	if tree.isTerminal() {
+		n++
+	}
+	for _, l := range tree.links {
+		n += checkCounts(t, l.tree)
+	}
+	if n != tree.descendants {
+		t.Errorf("Node \"%s\" counts %d descendants, expected %d.\n", tree.key, tree.descendants, n)
+	}
+	return n
+}
+
+func TestLen(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		if n := tree.Len(); n != 0 {
+			t.Errorf("Len() returned %d for an empty tree.\n", n)
+		}
+
+		stored := make(map[string]bool)
+		for i := 0; i < 200; i++ {
+			key := keys[rand.Intn(len(keys))]
+			if rand.Intn(3) == 0 {
+				tree.Delete(key)
+				delete(stored, key)
+			} else {
+				tree.Add(key, i)
+				stored[key] = true
+			}
+			if n := tree.Len(); n != len(stored) {
+				t.Errorf("Len() returned %d, expected %d.\n", n, len(stored))
+			}
+			checkCounts(t, tree)
+		}
+
+		// Re-adding a lone key must not make its prefixes ambiguous.
+		tree = New[int](opts...)
+		tree.Add("apple", 1)
+		tree.Add("apple", 2)
+		if key, err := tree.FindKey("ap"); key != "apple" || err != nil || tree.Len() != 1 {
+			t.Errorf("After re-adding \"apple\", FindKey(\"ap\") returned (\"%s\", %v) and Len() %d.\n",
+				key, err, tree.Len())
+		}
+	}
+}
+
+func TestRootFanout(t *testing.T) {
+	cases := []struct {
+		keys   []string
+		fanout int
+	}{
+		{[]string{}, 0},
+		{[]string{"apple"}, 1},
+		{[]string{"apple", "applepie", "arm"}, 1},
+		{[]string{"apple", "bee", "bog", "cat"}, 3},
+	}
+	for i, c := range cases {
+		for _, opts := range representations {
+			tree := New[int](opts...)
+			for _, key := range c.keys {
+				tree.Add(key, 0)
+			}
+			if n := tree.RootFanout(); n != c.fanout {
+				t.Errorf("Case %d: RootFanout() returned %d, expected %d.\n",
+					i, n, c.fanout)
+			}
+		}
+	}
+}
+
+func TestSampleKeys(t *testing.T) {
+	tree := New[int]()
+	for i, key := range []string{
+		"apple", "applepie", "apricot", "arm", "armor", "avocado", "bee", "bog",
+	} {
+		tree.Add(key, i)
+	}
+
+	cases := []struct {
+		prefix string
+		k      int
+		n      int
+	}{
+		{"", 3, 3},
+		{"a", 4, 4},
+		{"ap", 5, 3},
+		{"apple", 1, 1},
+		{"b", 2, 2},
+		{"c", 2, 0},
+		{"a", 0, 0},
+	}
+
+	for i, c := range cases {
+		keys := tree.SampleKeys(c.prefix, c.k, rand.New(rand.NewSource(int64(i))))
+		if len(keys) != c.n {
+			t.Errorf("Case %d: SampleKeys(\"%s\", %d) returned %v, expected %d keys.\n",
+				i, c.prefix, c.k, keys, c.n)
+		}
+		for j, key := range keys {
+			if !strings.HasPrefix(key, c.prefix) {
+				t.Errorf("Case %d: SampleKeys(\"%s\", %d) returned unmatched key %q.\n",
+					i, c.prefix, c.k, key)
+			}
+			if j > 0 && keys[j-1] >= key {
+				t.Errorf("Case %d: SampleKeys(\"%s\", %d) returned unsorted keys %v.\n",
+					i, c.prefix, c.k, keys)
+			}
+		}
+
+		again := tree.SampleKeys(c.prefix, c.k, rand.New(rand.NewSource(int64(i))))
+		if !slices.Equal(keys, again) {
+			t.Errorf("Case %d: SampleKeys(\"%s\", %d) returned %v, then %v with the same seed.\n",
+				i, c.prefix, c.k, keys, again)
+		}
+	}
+
+	// Every matching key should eventually be sampled.
+	counts := make(map[string]int)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		for _, key := range tree.SampleKeys("a", 2, rng) {
+			counts[key]++
+		}
+	}
+	if len(counts) != 6 {
+		t.Errorf("SampleKeys(\"a\", 2) sampled only %v.\n", counts)
+	}
+}
+
+func TestAnyInRange(t *testing.T) {
+	cases := []struct {
+		lo, hi string
+		result bool
+	}{
+		{"", "", false},
+		{"", "a", false},
+		{"", "apple", true},
+		{"apple", "apple", true},
+		{"applea", "applep", false},
+		{"applea", "applepie", true},
+		{"applepie", "applepie", true},
+		{"applepies", "arm", true},
+		{"applepies", "arl", false},
+		{"arm", "arm", true},
+		{"arma", "bed", false},
+		{"arma", "bee", true},
+		{"bef", "boa", false},
+		{"bog", "z", true},
+		{"boga", "z", false},
+		{"bog", "apple", false},
+	}
+
+	for i, c := range cases {
+		for _, opts := range representations {
+			tree := New[int](opts...)
+			for i, key := range []string{"apple", "applepie", "arm", "bee", "bog"} {
+				tree.Add(key, i)
+			}
+			if r := tree.AnyInRange(c.lo, c.hi); r != c.result {
+				t.Errorf("Case %d: AnyInRange(\"%s\", \"%s\") returned %v, expected %v.\n",
+					i, c.lo, c.hi, r, c.result)
+			}
+		}
+	}
+
+	if New[int]().AnyInRange("", "z") {
+		t.Errorf("AnyInRange on an empty tree returned true.\n")
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	cases := []struct {
+		prefix   string
+		min, max string
+	}{
+		{"", "a", "bog"},
+		{"a", "a", "armor"},
+		{"ap", "apple", "apricot"},
+		{"apple", "apple", "applepie"},
+		{"applep", "applepie", "applepie"},
+		{"ar", "arm", "armor"},
+		{"b", "bee", "bog"},
+		{"c", "", ""},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		keys := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			key, value, ok := tree.MinPrefix(c.prefix)
+			if key != c.min || ok != (c.min != "") || (ok && keys[value] != key) {
+				t.Errorf("Case %d: MinPrefix(\"%s\") returned (\"%s\", %d, %v), expected \"%s\".\n",
+					i, c.prefix, key, value, ok, c.min)
+			}
+			key, value, ok = tree.MaxPrefix(c.prefix)
+			if key != c.max || ok != (c.max != "") || (ok && keys[value] != key) {
+				t.Errorf("Case %d: MaxPrefix(\"%s\") returned (\"%s\", %d, %v), expected \"%s\".\n",
+					i, c.prefix, key, value, ok, c.max)
+			}
+		}
+
+		if key, _, ok := tree.Min(); key != "a" || !ok {
+			t.Errorf("Min() returned (\"%s\", %v), expected \"a\".\n", key, ok)
+		}
+		if key, _, ok := tree.Max(); key != "bog" || !ok {
+			t.Errorf("Max() returned (\"%s\", %v), expected \"bog\".\n", key, ok)
+		}
+	}
+
+	empty := New[int]()
+	if _, _, ok := empty.Min(); ok {
+		t.Errorf("Min() on an empty tree returned true.\n")
+	}
+	if _, _, ok := empty.Max(); ok {
+		t.Errorf("Max() on an empty tree returned true.\n")
+	}
+}
+
+func TestSurround(t *testing.T) {
+	cases := []struct {
+		key         string
+		floor, ceil string
+	}{
+		{"", "", "apple"},
+		{"a", "", "apple"},
+		{"apple", "apple", "apple"},
+		{"applea", "apple", "applepie"},
+		{"applepie", "applepie", "applepie"},
+		{"applepies", "applepie", "arm"},
+		{"applez", "applepie", "arm"},
+		{"aq", "applepie", "arm"},
+		{"arm", "arm", "arm"},
+		{"b", "arm", "bee"},
+		{"bed", "arm", "bee"},
+		{"beef", "bee", "bog"},
+		{"bog", "bog", "bog"},
+		{"boga", "bog", ""},
+		{"z", "bog", ""},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			floor, ceil, hasFloor, hasCeil := tree.Surround(c.key)
+			if hasFloor != (c.floor != "") || floor.Key != c.floor {
+				t.Errorf("Case %d: Surround(\"%s\") returned floor \"%s\" (%v), expected \"%s\".\n",
+					i, c.key, floor.Key, hasFloor, c.floor)
+			}
+			if hasCeil != (c.ceil != "") || ceil.Key != c.ceil {
+				t.Errorf("Case %d: Surround(\"%s\") returned ceiling \"%s\" (%v), expected \"%s\".\n",
+					i, c.key, ceil.Key, hasCeil, c.ceil)
+			}
+			if hasFloor && floor.Value != slices.Index(tree.FindKeys(""), floor.Key) {
+				t.Errorf("Case %d: Surround(\"%s\") returned floor value %d.\n", i, c.key, floor.Value)
+			}
+		}
+	}
+
+	if _, _, hasFloor, hasCeil := New[int]().Surround("a"); hasFloor || hasCeil {
+		t.Errorf("Surround on an empty tree returned (%v, %v).\n", hasFloor, hasCeil)
+	}
+}
+
+func TestGetContains(t *testing.T) {
+	cases := []struct {
+		key   string
+		value int
+		ok    bool
+	}{
+		{"", 0, false},
+		{"a", 0, false},
+		{"appl", 0, false},
+		{"apple", 1, true},
+		{"applep", 0, false},
+		{"applepie", 2, true},
+		{"applepies", 0, false},
+		{"arm", 3, true},
+		{"b", 0, false},
+		{"bee", 4, true},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+			tree.Add(key, i+1)
+		}
+		for i, c := range cases {
+			if value, ok := tree.Get(c.key); value != c.value || ok != c.ok {
+				t.Errorf("Case %d: Get(\"%s\") returned (%d, %v), expected (%d, %v).\n",
+					i, c.key, value, ok, c.value, c.ok)
+			}
+			if ok := tree.Contains(c.key); ok != c.ok {
+				t.Errorf("Case %d: Contains(\"%s\") returned %v, expected %v.\n",
+					i, c.key, ok, c.ok)
+			}
+		}
+	}
+}
+
+func TestGetPtr(t *testing.T) {
+	type record struct {
+		Name  string
+		Count int
+	}
+
+	for _, opts := range representations {
+		tree := New[record](opts...)
+		for _, key := range []string{"apple", "applepie", "arm"} {
+			tree.Add(key, record{Name: key})
+		}
+
+		for _, key := range []string{"", "appl", "applep", "bee"} {
+			if p, ok := tree.GetPtr(key); p != nil || ok {
+				t.Errorf("GetPtr(\"%s\") returned (%v, %v) for a key not in the tree.\n", key, p, ok)
+			}
+		}
+
+		for i := 0; i < 3; i++ {
+			p, ok := tree.GetPtr("apple")
+			if !ok {
+				t.Fatalf("GetPtr(\"apple\") returned false.\n")
+			}
+			p.Count++
+		}
+		if v, _ := tree.Get("apple"); v != (record{"apple", 3}) {
+			t.Errorf("Get(\"apple\") after updates through GetPtr returned %v.\n", v)
+		}
+		if v, _ := tree.Get("applepie"); v != (record{"applepie", 0}) {
+			t.Errorf("Get(\"applepie\") returned %v, expected it unchanged.\n", v)
+		}
+	}
+}
+
+func TestLongestPrefix(t *testing.T) {
+	cases := []struct {
+		s     string
+		key   string
+		value int
+		ok    bool
+	}{
+		{"", "", 0, false},
+		{"/", "", 0, false},
+		{"/ap", "", 0, false},
+		{"/api", "/api", 1, true},
+		{"/api/", "/api", 1, true},
+		{"/api/v", "/api", 1, true},
+		{"/api/v1", "/api/v1", 2, true},
+		{"/api/v1/users", "/api/v1", 2, true},
+		{"/api/v2/users", "/api", 1, true},
+		{"/apiary", "/api", 1, true},
+		{"/static/css/site.css", "/static", 3, true},
+		{"/stat", "", 0, false},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"/api", "/api/v1", "/static", "/api/v1/users/admin"} {
+			tree.Add(key, i+1)
+		}
+		for i, c := range cases {
+			key, value, ok := tree.LongestPrefix(c.s)
+			if key != c.key || value != c.value || ok != c.ok {
+				t.Errorf("Case %d: LongestPrefix(\"%s\") returned (\"%s\", %d, %v), expected (\"%s\", %d, %v).\n",
+					i, c.s, key, value, ok, c.key, c.value, c.ok)
+			}
+			if has := tree.HasPrefixOf(c.s); has != c.ok {
+				t.Errorf("Case %d: HasPrefixOf(\"%s\") returned %v, expected %v.\n", i, c.s, has, c.ok)
+			}
+		}
+	}
+}
+
+func TestFindNearest(t *testing.T) {
+	cases := []struct {
+		s     string
+		key   string
+		value int
+	}{
+		{"applr", "apple", 1},
+		{"apple", "apple", 1},
+		{"applepic", "applepie", 2},
+		{"applepies", "applepie", 2},
+		{"ap", "apple", 1},
+		{"apz", "apple", 1},
+		{"armchair", "arm", 3},
+		{"as", "a", 0},
+		{"a", "a", 0},
+		{"bz", "bee", 4},
+		{"boa", "bog", 5},
+		{"", "a", 0},
+		{"zebra", "a", 0},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		if _, _, ok := tree.FindNearest("apple"); ok {
+			t.Errorf("FindNearest returned true for an empty tree.\n")
+		}
+		for i, key := range []string{"a", "apple", "applepie", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			key, value, ok := tree.FindNearest(c.s)
+			if key != c.key || value != c.value || !ok {
+				t.Errorf("Case %d: FindNearest(\"%s\") returned (\"%s\", %d, %v), expected (\"%s\", %d, true).\n",
+					i, c.s, key, value, ok, c.key, c.value)
+			}
+		}
+	}
+}
+
+func TestValuesForKeys(t *testing.T) {
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+			tree.Add(key, i+1)
+		}
+
+		keys := []string{"bee", "appl", "apple", "", "arm", "armor", "applepie", "b"}
+		values, found := tree.ValuesForKeys(keys)
+		expectedValues := []int{4, 0, 1, 0, 3, 0, 2, 0}
+		expectedFound := []bool{true, false, true, false, true, false, true, false}
+		if !slices.Equal(values, expectedValues) || !slices.Equal(found, expectedFound) {
+			t.Errorf("ValuesForKeys(%q) returned (%v, %v), expected (%v, %v).\n",
+				keys, values, found, expectedValues, expectedFound)
+		}
+	}
+}
+
+func TestPrefixKeys(t *testing.T) {
+	cases := []struct {
+		keys     []string
+		prefixes []string
+	}{
+		{[]string{}, []string{}},
+		{[]string{"apple", "bee"}, []string{}},
+		{[]string{"apple", "applepie", "apples", "arm"}, []string{"apple"}},
+		{[]string{"a", "apple", "applepie", "be", "bee", "bog"}, []string{"a", "apple", "be"}},
+	}
+
+	for _, opts := range representations {
+		for i, c := range cases {
+			tree := New[int](opts...)
+			for j, key := range c.keys {
+				tree.Add(key, j)
+			}
+			if prefixes := tree.PrefixKeys(); !slices.Equal(prefixes, c.prefixes) {
+				t.Errorf("Case %d: PrefixKeys() returned %v, expected %v.\n",
+					i, prefixes, c.prefixes)
+			}
+		}
+	}
+}
+
+func TestKeysByLength(t *testing.T) {
+	tree := New[int]()
+	for i, key := range []string{"a", "apple", "applepie", "arm", "ant", "angle", "bee"} {
+		tree.Add(key, i)
+	}
+
+	cases := []struct {
+		prefix string
+		groups map[int][]string
+	}{
+		{"", map[int][]string{
+			1: {"a"},
+			3: {"ant", "arm", "bee"},
+			5: {"angle", "apple"},
+			8: {"applepie"},
+		}},
+		{"a", map[int][]string{
+			1: {"a"},
+			3: {"ant", "arm"},
+			5: {"angle", "apple"},
+			8: {"applepie"},
+		}},
+		{"ap", map[int][]string{5: {"apple"}, 8: {"applepie"}}},
+		{"apple", map[int][]string{5: {"apple"}, 8: {"applepie"}}},
+		{"b", map[int][]string{3: {"bee"}}},
+		{"c", map[int][]string{}},
+	}
+
+	for i, c := range cases {
+		groups := tree.KeysByLength(c.prefix)
+		if len(groups) != len(c.groups) {
+			t.Errorf("Case %d: KeysByLength(\"%s\") returned %v, expected %v.\n",
+				i, c.prefix, groups, c.groups)
+			continue
+		}
+		for n, keys := range c.groups {
+			if !slices.Equal(groups[n], keys) {
+				t.Errorf("Case %d: KeysByLength(\"%s\")[%d] is %v, expected %v.\n",
+					i, c.prefix, n, groups[n], keys)
+			}
+		}
+	}
+}
+
+func TestKeyAt(t *testing.T) {
+	keys := []string{"a", "angle", "ant", "apple", "applepie", "arm", "bee", "bog"}
+	prefixes := []string{"", "a", "an", "ap", "apple", "b", "bog", "c"}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for _, i := range rand.Perm(len(keys)) {
+			tree.Add(keys[i], i)
+		}
+
+		for i, prefix := range prefixes {
+			var expected []string
+			for _, key := range keys {
+				if strings.HasPrefix(key, prefix) {
+					expected = append(expected, key)
+				}
+			}
+
+			for rank := -1; rank <= len(expected); rank++ {
+				kv, ok := tree.KeyAt(prefix, rank)
+				switch {
+				case rank < 0 || rank >= len(expected):
+					if ok {
+						t.Errorf("Case %d: KeyAt(\"%s\", %d) returned %q, expected nothing.\n",
+							i, prefix, rank, kv.Key)
+					}
+				case !ok || kv.Key != expected[rank] || keys[kv.Value] != kv.Key:
+					t.Errorf("Case %d: KeyAt(\"%s\", %d) returned (%v, %v), expected %q.\n",
+						i, prefix, rank, kv, ok, expected[rank])
+				}
+			}
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for _, i := range rand.Perm(len(keys)) {
+			tree.Add(keys[i], i)
+		}
+
+		for _, key := range []string{"", "ap", "appl", "b", "cat"} {
+			if tree.Delete(key) {
+				t.Errorf("Delete(\"%s\") returned true for a key not in the tree.\n", key)
+			}
+		}
+
+		remaining := slices.Clone(keys)
+		for _, i := range rand.Perm(len(keys)) {
+			if !tree.Delete(keys[i]) {
+				t.Errorf("Delete(\"%s\") returned false.\n", keys[i])
+			}
+			if tree.Delete(keys[i]) {
+				t.Errorf("Delete(\"%s\") returned true for a deleted key.\n", keys[i])
+			}
+			remaining = slices.DeleteFunc(remaining, func(k string) bool { return k == keys[i] })
+
+			// The tree should have the same shape as one built from scratch
+			// without the deleted keys, and resolve prefixes the same way.
+			fresh := New[int](opts...)
+			for _, key := range remaining {
+				fresh.Add(key, slices.Index(keys, key))
+			}
+			c := CompareTrees(tree, fresh)
+			if !c.SameKeys || c.NodesA != c.NodesB || c.SegmentBytesA != c.SegmentBytesB {
+				t.Errorf("Delete(\"%s\") produced a tree differing from a fresh build: %+v.\n",
+					keys[i], c)
+			}
+			for _, key := range keys {
+				for j := 1; j <= len(key); j++ {
+					v1, err1 := tree.FindValue(key[:j])
+					v2, err2 := fresh.FindValue(key[:j])
+					if v1 != v2 || err1 != err2 {
+						t.Errorf("FindValue(\"%s\") returned (%d, %v), expected (%d, %v).\n",
+							key[:j], v1, err1, v2, err2)
+					}
+				}
+			}
+		}
+
+		if keys := tree.FindKeys(""); len(keys) != 0 || tree.RootFanout() != 0 {
+			t.Errorf("Tree holds %v after deleting every key.\n", keys)
+		}
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+
+	cases := []struct {
+		prefix  string
+		removed []string
+	}{
+		{"apple", []string{"apple", "applepie"}},
+		{"appl", []string{"apple", "applepie"}},
+		{"ap", []string{"apple", "applepie", "apricot"}},
+		{"arm", []string{"arm", "armor"}},
+		{"armo", []string{"armor"}},
+		{"applepie", []string{"applepie"}},
+		{"a", []string{"a", "apple", "applepie", "apricot", "arm", "armor"}},
+		{"b", []string{"bee", "bog"}},
+		{"", keys},
+		{"applex", nil},
+		{"c", nil},
+		{"bees", nil},
+	}
+
+	for _, opts := range representations {
+		for i, c := range cases {
+			tree := New[int](opts...)
+			for _, j := range rand.Perm(len(keys)) {
+				tree.Add(keys[j], j)
+			}
+
+			if n := tree.DeletePrefix(c.prefix); n != len(c.removed) {
+				t.Errorf("Case %d: DeletePrefix(\"%s\") returned %d, expected %d.\n",
+					i, c.prefix, n, len(c.removed))
+			}
+
+			// The tree should have the same shape as one built from scratch
+			// without the deleted keys, and resolve prefixes the same way.
+			fresh := New[int](opts...)
+			for j, key := range keys {
+				if !slices.Contains(c.removed, key) {
+					fresh.Add(key, j)
+				}
+			}
+			cmp := CompareTrees(tree, fresh)
+			if !cmp.SameKeys || cmp.NodesA != cmp.NodesB || cmp.SegmentBytesA != cmp.SegmentBytesB {
+				t.Errorf("Case %d: DeletePrefix(\"%s\") produced a tree differing from a fresh build: %+v.\n",
+					i, c.prefix, cmp)
+			}
+			if n, expected := tree.Len(), fresh.Len(); n != expected {
+				t.Errorf("Case %d: Len returned %d, expected %d.\n", i, n, expected)
+			}
+			for _, key := range keys {
+				for j := 0; j <= len(key); j++ {
+					if n1, n2 := tree.CountPrefix(key[:j]), fresh.CountPrefix(key[:j]); n1 != n2 {
+						t.Errorf("Case %d: CountPrefix(\"%s\") returned %d, expected %d.\n",
+							i, key[:j], n1, n2)
+					}
+					v1, err1 := tree.FindValue(key[:j])
+					v2, err2 := fresh.FindValue(key[:j])
+					if v1 != v2 || err1 != err2 {
+						t.Errorf("Case %d: FindValue(\"%s\") returned (%d, %v), expected (%d, %v).\n",
+							i, key[:j], v1, err1, v2, err2)
+					}
+				}
+			}
+
+			// The tree remains usable after the deletion.
+			tree.Add("apple", 100)
+			if v, ok := tree.Get("apple"); v != 100 || !ok {
+				t.Errorf("Case %d: Get(\"apple\") after re-adding returned (%d, %t).\n", i, v, ok)
+			}
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	for _, opts := range representations {
+		base := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+			base.Add(key, i)
+		}
+
+		clone := base.Clone()
+		clone.Add("apply", 10)
+		clone.Add("arm", 11)
+		clone.Delete("bee")
+		base.Add("bog", 12)
+		base.Delete("applepie")
+
+		expectedBase := []KeyValue[int]{{"apple", 0}, {"arm", 2}, {"bee", 3}, {"bog", 12}}
+		if kvs := base.FindKeyValues(""); !slices.Equal(kvs, expectedBase) {
+			t.Errorf("Original tree holds %v, expected %v.\n", kvs, expectedBase)
+		}
+		expectedClone := []KeyValue[int]{{"apple", 0}, {"applepie", 1}, {"apply", 10}, {"arm", 11}}
+		if kvs := clone.FindKeyValues(""); !slices.Equal(kvs, expectedClone) {
+			t.Errorf("Cloned tree holds %v, expected %v.\n", kvs, expectedClone)
+		}
+		if clone.opts.isUncompressed() != base.opts.isUncompressed() {
+			t.Errorf("Cloned tree has a different representation than the original.\n")
+		}
+		if key, err := clone.FindKey("appl"); err != ErrPrefixAmbiguous {
+			t.Errorf("FindKey(\"appl\") on the clone returned (\"%s\", %v), expected %v.\n",
+				key, err, ErrPrefixAmbiguous)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "arm", "bee"}
+	eq := func(a, b int) bool { return a == b }
+
+	build := func(keys []string, values []int, opts ...Option) *Tree[int] {
+		tree := New[int](opts...)
+		for _, i := range rand.Perm(len(keys)) {
+			tree.Add(keys[i], values[i])
+		}
+		return tree
+	}
+
+	tree := build(keys, []int{1, 2, 3, 4, 5})
+	cases := []struct {
+		other *Tree[int]
+		equal bool
+	}{
+		{build(keys, []int{1, 2, 3, 4, 5}), true},
+		{build(keys, []int{1, 2, 3, 4, 5}, WithoutCompression()), true},
+		{build(keys, []int{1, 2, 3, 4, 6}), false},
+		{build(keys[:4], []int{1, 2, 3, 4}), false},
+		{build([]string{"a", "apple", "applepie", "arm", "bed"}, []int{1, 2, 3, 4, 5}), false},
+		{build([]string{"A", "apple", "applepie", "arm", "bee"}, []int{1, 2, 3, 4, 5}, WithCaseFolding()), false},
+		{New[int](), false},
+	}
+	for i, c := range cases {
+		if equal := tree.Equal(c.other, eq); equal != c.equal {
+			t.Errorf("Case %d: Equal returned %v, expected %v.\n", i, equal, c.equal)
+		}
+		if equal := c.other.Equal(tree, eq); equal != c.equal {
+			t.Errorf("Case %d: reversed Equal returned %v, expected %v.\n", i, equal, c.equal)
+		}
+	}
+}
+
+func TestDiffIntersectKeys(t *testing.T) {
+	cases := []struct {
+		a, b                    []string
+		onlyA, onlyB, intersect []string
+	}{
+		{
+			[]string{"a", "apple", "applepie", "arm", "bee"},
+			[]string{"apple", "applepies", "arm", "armor", "bog"},
+			[]string{"a", "applepie", "bee"},
+			[]string{"applepies", "armor", "bog"},
+			[]string{"apple", "arm"},
+		},
+		{
+			[]string{"apple", "bee"},
+			[]string{"apple", "bee"},
+			[]string{},
+			[]string{},
+			[]string{"apple", "bee"},
+		},
+		{
+			[]string{"apple", "bee"},
+			[]string{},
+			[]string{"apple", "bee"},
+			[]string{},
+			[]string{},
+		},
+		{
+			[]string{},
+			[]string{"apple"},
+			[]string{},
+			[]string{"apple"},
+			[]string{},
+		},
+		{
+			[]string{"b", "d"},
+			[]string{"a", "c", "e"},
+			[]string{"b", "d"},
+			[]string{"a", "c", "e"},
+			[]string{},
+		},
+	}
+
+	for _, opts := range representations {
+		for i, c := range cases {
+			a, b := New[int](opts...), New[int](opts...)
+			for j, key := range c.a {
+				a.Add(key, j)
+			}
+			for j, key := range c.b {
+				b.Add(key, j)
+			}
+			onlyA, onlyB := a.DiffKeys(b)
+			if !slices.Equal(onlyA, c.onlyA) || !slices.Equal(onlyB, c.onlyB) {
+				t.Errorf("Case %d: DiffKeys returned (%v, %v), expected (%v, %v).\n",
+					i, onlyA, onlyB, c.onlyA, c.onlyB)
+			}
+			if keys := a.IntersectKeys(b); !slices.Equal(keys, c.intersect) {
+				t.Errorf("Case %d: IntersectKeys returned %v, expected %v.\n", i, keys, c.intersect)
+			}
+		}
+	}
+
+	// Case-folding trees match keys differing only in case.
+	a, b := New[int](WithCaseFolding()), New[int](WithCaseFolding())
+	for _, key := range []string{"Apple", "bee", "Cat"} {
+		a.Add(key, 0)
+	}
+	for _, key := range []string{"APPLE", "cat", "dog"} {
+		b.Add(key, 0)
+	}
+	if onlyA, onlyB := a.DiffKeys(b); !slices.Equal(onlyA, []string{"bee"}) || !slices.Equal(onlyB, []string{"dog"}) {
+		t.Errorf("DiffKeys of case-folding trees returned (%v, %v).\n", onlyA, onlyB)
+	}
+	if keys := a.IntersectKeys(b); !slices.Equal(keys, []string{"Apple", "Cat"}) {
+		t.Errorf("IntersectKeys of case-folding trees returned %v.\n", keys)
+	}
+}
+
+func TestClear(t *testing.T) {
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		tree.SetTerminalPrefixMode(TerminalAmbiguous)
+		for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+			tree.Add(key, i)
+		}
+
+		tree.Clear()
+		if keys := tree.FindKeys(""); len(keys) != 0 || tree.Len() != 0 {
+			t.Errorf("Tree holds %v after Clear, expected nothing.\n", keys)
+		}
+		if _, err := tree.FindKey("a"); err != ErrPrefixNotFound {
+			t.Errorf("FindKey(\"a\") after Clear returned %v, expected %v.\n", err, ErrPrefixNotFound)
+		}
+
+		// The cleared tree is reusable and keeps its configuration.
+		tree.Add("apple", 1)
+		tree.Add("applepie", 2)
+		if _, err := tree.FindKey("apple"); err != ErrPrefixAmbiguous {
+			t.Errorf("FindKey(\"apple\") after reuse returned %v, expected %v.\n", err, ErrPrefixAmbiguous)
+		}
+		if n := tree.Len(); n != 2 {
+			t.Errorf("Len() after reuse returned %d, expected 2.\n", n)
+		}
+	}
+}
+
+func TestReAddCounts(t *testing.T) {
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i := 0; i < 10; i++ {
+			tree.Add("apple", i)
+			if tree.descendants != 1 {
+				t.Errorf("After adding \"apple\" %d times, the root counts %d descendants, expected 1.\n",
+					i+1, tree.descendants)
+			}
+			checkCounts(t, tree)
+		}
+
+		// Re-adding a key that other keys pass through leaves their counts
+		// unchanged too.
+		tree.Add("applepie", 0)
+		tree.Add("arm", 0)
+		for i := 0; i < 10; i++ {
+			tree.Add("apple", i)
+			tree.Add("applepie", i)
+		}
+		if tree.descendants != 3 {
+			t.Errorf("The root counts %d descendants, expected 3.\n", tree.descendants)
+		}
+		checkCounts(t, tree)
+
+		// The empty key is never stored, so adding it changes no counts.
+		for i := 0; i < 3; i++ {
+			tree.Add("", i)
+			if tree.AddReport("", i) {
+				t.Errorf("AddReport(\"\") reported the empty key as added.\n")
+			}
+			if v, added := tree.AddIfAbsent("", i); v != 0 || added {
+				t.Errorf("AddIfAbsent(\"\") returned (%d, %v), expected (0, false).\n", v, added)
+			}
+		}
+		if n, keys := tree.Len(), tree.Keys(); n != 3 || len(keys) != 3 {
+			t.Errorf("After adding the empty key, Len() returned %d and Keys() %v, expected 3 keys.\n", n, keys)
+		}
+		checkCounts(t, tree)
+
+		empty := New[int](opts...)
+		empty.Add("", 1)
+		empty.AddSorted([]KeyValue[int]{{"", 2}, {"", 3}})
+		if n := empty.Len(); n != 0 || empty.isTerminal() {
+			t.Errorf("Len() of a tree holding only the empty key returned %d, expected 0.\n", n)
+		}
+	}
+
+	m := NewMulti[int]()
+	m.Add("", 1)
+	m.Add("", 2)
+	if n := m.Len(); n != 0 {
+		t.Errorf("MultiTree Len() after adding the empty key returned %d, expected 0.\n", n)
+	}
+}
+
+func TestAddReport(t *testing.T) {
+	cases := []struct {
+		key     string
+		created bool
+	}{
+		{"apple", true},
+		{"apple", false},
+		{"applepie", true},
+		{"a", true},
+		{"applepie", false},
+		{"arm", true},
+		{"a", false},
+		{"ap", true},
+		{"apple", false},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		stored := make(map[string]int)
+		for i, c := range cases {
+			if created := tree.AddReport(c.key, i); created != c.created {
+				t.Errorf("Case %d: AddReport(\"%s\") returned %v, expected %v.\n",
+					i, c.key, created, c.created)
+			}
+			stored[c.key] = i
+			if n := tree.Len(); n != len(stored) {
+				t.Errorf("Case %d: Len() returned %d, expected %d.\n", i, n, len(stored))
+			}
+			checkCounts(t, tree)
+		}
+		for key, value := range stored {
+			if v, ok := tree.Get(key); v != value || !ok {
+				t.Errorf("Get(\"%s\") returned (%d, %v), expected (%d, true).\n", key, v, ok, value)
+			}
+		}
+	}
+}
+
+func TestAddIfAbsent(t *testing.T) {
+	cases := []struct {
+		key    string
+		value  int
+		stored int
+		added  bool
+	}{
+		{"apple", 10, 1, false},
+		{"appl", 11, 11, true},
+		{"applepie", 12, 2, false},
+		{"apples", 13, 13, true},
+		{"b", 14, 14, true},
+		{"b", 15, 14, false},
+		{"arm", 16, 3, false},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "arm"} {
+			tree.Add(key, i+1)
+		}
+		for i, c := range cases {
+			stored, added := tree.AddIfAbsent(c.key, c.value)
+			if stored != c.stored || added != c.added {
+				t.Errorf("Case %d: AddIfAbsent(\"%s\", %d) returned (%d, %v), expected (%d, %v).\n",
+					i, c.key, c.value, stored, added, c.stored, c.added)
+			}
+			if value, ok := tree.Get(c.key); value != c.stored || !ok {
+				t.Errorf("Case %d: Get(\"%s\") returned (%d, %v), expected (%d, true).\n",
+					i, c.key, value, ok, c.stored)
+			}
+		}
+		if n := tree.Len(); n != 6 {
+			t.Errorf("Len() returned %d, expected 6.\n", n)
+		}
+		if value, err := tree.FindValue("ar"); value != 3 || err != nil {
+			t.Errorf("FindValue(\"ar\") returned (%d, %v), expected (3, nil).\n", value, err)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	increment := func(old int) int { return old + 1 }
+
+	cases := []struct {
+		key     string
+		updated bool
+	}{
+		{"apple", true},
+		{"appl", false},
+		{"applepie", true},
+		{"applepies", false},
+		{"apple", true},
+		{"", false},
+		{"bee", true},
+		{"b", false},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		expected := map[string]int{}
+		for _, key := range []string{"apple", "applepie", "arm", "bee"} {
+			tree.Add(key, 0)
+			expected[key] = 0
+		}
+		for i, c := range cases {
+			if updated := tree.Update(c.key, increment); updated != c.updated {
+				t.Errorf("Case %d: Update(\"%s\") returned %v, expected %v.\n",
+					i, c.key, updated, c.updated)
+			}
+			if c.updated {
+				expected[c.key]++
+			}
+		}
+		for key, value := range expected {
+			if v, ok := tree.Get(key); v != value || !ok {
+				t.Errorf("Get(\"%s\") returned (%d, %v), expected (%d, true).\n", key, v, ok, value)
+			}
+		}
+		if n := tree.Len(); n != len(expected) {
+			t.Errorf("Len returned %d, expected %d.\n", n, len(expected))
+		}
+	}
+}
+
+func TestAddSorted(t *testing.T) {
+	initial := []string{"apple", "arm"}
+	sorted := []string{"a", "apple", "applepie", "apply", "apricot", "arm", "armor", "armory", "bee", "bog"}
+	unsorted := []string{"bee", "ape", "b", "armor", "zoo", "bog", "bee"}
+
+	for _, opts := range [][]Option{nil, {WithoutCompression()}, {WithCaseFolding()}} {
+		for _, keys := range [][]string{sorted, unsorted, append(slices.Clone(sorted), unsorted...)} {
+			kvs := make([]KeyValue[int], len(keys))
+			for i, key := range keys {
+				kvs[i] = KeyValue[int]{key, i}
+			}
+
+			expected := New[int](opts...)
+			tree := New[int](opts...)
+			all := New[int](opts...)
+			for i, key := range initial {
+				expected.Add(key, 100+i)
+				tree.Add(key, 100+i)
+				all.Add(key, 100+i)
+			}
+			for _, kv := range kvs {
+				expected.Add(kv.Key, kv.Value)
+			}
+			tree.AddSorted(kvs)
+			all.AddAll(kvs)
+
+			for _, built := range []*Tree[int]{tree, all} {
+				if got, want := built.FindKeyValues(""), expected.FindKeyValues(""); !slices.Equal(got, want) {
+					t.Errorf("Tree built from %v holds %v, expected %v.\n", keys, got, want)
+				}
+				c := CompareTrees(built, expected)
+				if c.NodesA != c.NodesB || c.SegmentBytesA != c.SegmentBytesB || built.Len() != expected.Len() {
+					t.Errorf("Tree built from %v differs from one built by Add: %+v.\n", keys, c)
+				}
+				for _, key := range keys {
+					for i := 0; i <= len(key); i++ {
+						v1, err1 := built.FindValue(key[:i])
+						v2, err2 := expected.FindValue(key[:i])
+						if v1 != v2 || err1 != err2 {
+							t.Errorf("FindValue(\"%s\") returned (%d, %v), expected (%d, %v).\n",
+								key[:i], v1, err1, v2, err2)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	initial := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+	upserts := map[string]int{
+		"apple":  10,
+		"apply":  11,
+		"arm":    12,
+		"beetle": 13,
+		"bog":    14,
+	}
+	deletes := []string{"a", "applepie", "arm", "armor", "bog", "cat"}
+	expected := map[string]int{
+		"apple":   10,
+		"apply":   11,
+		"apricot": 3,
+		"arm":     12,
+		"bee":     6,
+		"beetle":  13,
+		"bog":     14,
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for _, i := range rand.Perm(len(initial)) {
+			tree.Add(initial[i], i)
+		}
+
+		added, updated, removed := tree.ApplyDelta(upserts, deletes)
+		if added != 2 || updated != 3 || removed != 3 {
+			t.Errorf("ApplyDelta returned (%d, %d, %d), expected (2, 3, 3).\n",
+				added, updated, removed)
+		}
+
+		kvs := tree.FindKeyValues("")
+		if len(kvs) != len(expected) {
+			t.Errorf("Tree holds %v after ApplyDelta, expected %v.\n", kvs, expected)
+		}
+		for _, kv := range kvs {
+			if value, ok := expected[kv.Key]; !ok || value != kv.Value {
+				t.Errorf("Tree holds %v after ApplyDelta, expected %v.\n", kv, expected)
+			}
+		}
+
+		// The tree should have the same shape as one built from scratch.
+		fresh := New[int](opts...)
+		for key, value := range expected {
+			fresh.Add(key, value)
+		}
+		c := CompareTrees(tree, fresh)
+		if !c.SameKeys || c.NodesA != c.NodesB || c.SegmentBytesA != c.SegmentBytesB {
+			t.Errorf("ApplyDelta produced a tree differing from a fresh build: %+v.\n", c)
+		}
+		for key := range expected {
+			for i := 1; i <= len(key); i++ {
+				v1, err1 := tree.FindValue(key[:i])
+				v2, err2 := fresh.FindValue(key[:i])
+				if v1 != v2 || err1 != err2 {
+					t.Errorf("FindValue(\"%s\") returned (%d, %v), expected (%d, %v).\n",
+						key[:i], v1, err1, v2, err2)
+				}
+			}
+		}
+	}
+
+	// In a case-folding tree, keys matching alike conflict however they are
+	// cased, and the result is the same whatever order the map yields.
+	for i := 0; i < 20; i++ {
+		tree := New[int](WithCaseFolding())
+		tree.Add("Apple", 1)
+		tree.Add("Bee", 2)
+		tree.Add("Cat", 3)
+		added, updated, removed := tree.ApplyDelta(
+			map[string]int{"APPLE": 10, "apple": 11, "Apple": 12, "bee": 13, "dog": 14},
+			[]string{"BEE", "cat", "DOG"})
+		if added != 1 || updated != 4 || removed != 1 {
+			t.Errorf("ApplyDelta returned (%d, %d, %d), expected (1, 4, 1).\n", added, updated, removed)
+		}
+		expected := []KeyValue[int]{{"apple", 11}, {"bee", 13}, {"dog", 14}}
+		if kvs := tree.FindKeyValues(""); !slices.Equal(kvs, expected) {
+			t.Errorf("Tree holds %v after ApplyDelta, expected %v.\n", kvs, expected)
+			break
+		}
+		checkCounts(t, tree)
+	}
+}
+
+func TestString(t *testing.T) {
+	type point struct{ x, y int }
+	tree := New[*point]()
+	tree.Add("apple", &point{1, 2})
+	tree.Add("applepie", &point{3, 4})
+
+	expected := `Node: key="" term=false desc=2 value=<nil>
+  Link 0: ks="apple"
+    Node: key="apple" term=true desc=2 value=(1,2)
+      Link 0: ks="pie"
+        Node: key="applepie" term=true desc=1 value=(3,4)
+`
+	s := tree.StringFunc(func(p *point) string {
+		if p == nil {
+			return "<nil>"
+		}
+		return fmt.Sprintf("(%d,%d)", p.x, p.y)
+	})
+	if s != expected {
+		t.Errorf("StringFunc returned:\n%s\nexpected:\n%s", s, expected)
+	}
+
+	ints := New[int]()
+	ints.Add("apple", 1)
+	ints.Add("arm", 2)
+	expected = `Node: key="" term=false desc=2 value=0
+  Link 0: ks="a"
+    Node: key="" term=false desc=2 value=0
+      Link 0: ks="pple"
+        Node: key="apple" term=true desc=1 value=1
+      Link 1: ks="rm"
+        Node: key="arm" term=true desc=1 value=2
+`
+	if s := ints.String(); s != expected {
+		t.Errorf("String returned:\n%s\nexpected:\n%s", s, expected)
+	}
+
+	var b bytes.Buffer
+	ints.Fprint(&b)
+	if s := b.String(); s != expected {
+		t.Errorf("Fprint wrote:\n%s\nexpected:\n%s", s, expected)
+	}
+
+	expected = `Node: key="" term=false desc=2 value=-
+  Link 0: ks="a"
+    Node: key="" term=false desc=2 value=-
+      Link 0: ks="pple"
+        Node: key="apple" term=true desc=1 value=APPLE#1
+      Link 1: ks="rm"
+        Node: key="arm" term=true desc=1 value=ARM#2
+`
+	b.Reset()
+	ints.Fprintf(&b, func(key string, value int) string {
+		if key == "" {
+			return "-"
+		}
+		return fmt.Sprintf("%s#%d", strings.ToUpper(key), value)
+	})
+	if s := b.String(); s != expected {
+		t.Errorf("Fprintf wrote:\n%s\nexpected:\n%s", s, expected)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	tree := New[int]()
+	tree.Add("apple", 1)
+	tree.Add("applepie", 2)
+	tree.Add("arm", 3)
+
+	expected := `digraph prefixtree {
+	n0 [shape=circle label=""];
+	n0 -> n1 [label="a"];
+	n1 [shape=circle label=""];
+	n1 -> n2 [label="pple"];
+	n2 [shape=doublecircle label="apple\n1"];
+	n2 -> n3 [label="pie"];
+	n3 [shape=doublecircle label="applepie\n2"];
+	n1 -> n4 [label="rm"];
+	n4 [shape=doublecircle label="arm\n3"];
+}
+`
+	var b bytes.Buffer
+	tree.WriteDOT(&b)
+	if s := b.String(); s != expected {
+		t.Errorf("WriteDOT wrote:\n%s\nexpected:\n%s", s, expected)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	cases := []struct {
+		prefix     string
+		completion string
+		keys       []string
+	}{
+		{"", "", []string{"add", "commit", "commitment", "config", "push"}},
+		{"c", "co", []string{"commit", "commitment", "config"}},
+		{"com", "commit", []string{"commit", "commitment"}},
+		{"commit", "commit", []string{"commit", "commitment"}},
+		{"commitm", "commitment", []string{"commitment"}},
+		{"p", "push", []string{"push"}},
+		{"push", "push", []string{"push"}},
+		{"pushy", "pushy", []string{}},
+		{"x", "x", []string{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"commit", "config", "add", "commitment", "push"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			completion, keys := tree.Complete(c.prefix)
+			if completion != c.completion || !slices.Equal(keys, c.keys) {
+				t.Errorf("Case %d: Complete(\"%s\") returned (\"%s\", %v), expected (\"%s\", %v).\n",
+					i, c.prefix, completion, keys, c.completion, c.keys)
+			}
+		}
+	}
+
+	// Keys differing in case share no more than the prefix.
+	tree := New[int](WithCaseFolding())
+	tree.Add("Commit", 0)
+	tree.Add("commitment", 1)
+	if completion, keys := tree.Complete("com"); completion != "com" || len(keys) != 2 {
+		t.Errorf("Complete(\"com\") on a case-folding tree returned (\"%s\", %v).\n", completion, keys)
+	}
+	if completion, _ := tree.Complete("COMMITM"); completion != "commitment" {
+		t.Errorf("Complete(\"COMMITM\") on a case-folding tree returned \"%s\".\n", completion)
+	}
+}
+
+func TestCompleteBoundaries(t *testing.T) {
+	dots := func(s string) []string { return strings.Split(s, ".") }
+	cases := []struct {
+		opts       []Option
+		keys       []string
+		prefix     string
+		completion string
+	}{
+		{nil, []string{"café", "cafè"}, "ca", "caf"},
+		{[]Option{WithRuneBoundaries()}, []string{"café", "cafè"}, "ca", "caf"},
+		{[]Option{WithoutCompression(), WithRuneBoundaries()}, []string{"café", "cafè"}, "ca", "caf"},
+		{nil, []string{"日本", "日曜"}, "", "日"},
+		{nil, []string{"日本", "月曜"}, "", ""},
+		{[]Option{WithSplitter(dots)}, []string{"a.b.c", "a.b.d"}, "a", "a.b"},
+		{[]Option{WithSplitter(dots)}, []string{"a.bc", "a.bd"}, "a", "a"},
+		{[]Option{WithSplitter(dots)}, []string{"a.bc", "a.bd"}, "a.bc", "a.bc"},
+		{[]Option{WithSplitter(SplitRunes)}, []string{"café", "cafè"}, "c", "caf"},
+	}
+
+	for i, c := range cases {
+		tree := New[int](c.opts...)
+		for j, key := range c.keys {
+			tree.Add(key, j)
+		}
+		if completion, _ := tree.Complete(c.prefix); completion != c.completion {
+			t.Errorf("Case %d: Complete(%q) returned %q, expected %q.\n", i, c.prefix, completion, c.completion)
+		}
+	}
+}
+
+func TestNextChars(t *testing.T) {
+	cases := []struct {
+		prefix string
+		chars  []rune
+	}{
+		{"", []rune{'a', 'c', 'n'}},
+		{"a", []rune{'p', 'r'}},
+		{"ap", []rune{'p'}},
+		{"appl", []rune{'e', 'y'}},
+		{"apple", []rune{'p'}},
+		{"applep", []rune{'i'}},
+		{"applepie", []rune{}},
+		{"apply", []rune{}},
+		{"ar", []rune{'m'}},
+		{"caf", []rune{'e', 't', 'é', 'ê'}},
+		{"café", []rune{'s'}},
+		{"na", []rune{'ï', 'ñ'}},
+		{"b", []rune{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		keys := []string{"apple", "applepie", "apply", "arm", "cafe", "café", "cafés", "caftê", "cafê", "naïve", "naña"}
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			if chars := tree.NextChars(c.prefix); !slices.Equal(chars, c.chars) {
+				t.Errorf("Case %d: NextChars(%q) returned %q, expected %q.\n", i, c.prefix, chars, c.chars)
+			}
+		}
+	}
+}
+
+func TestCompleteAllowed(t *testing.T) {
+	except := func(excluded byte) func(byte) bool {
+		return func(c byte) bool { return c != excluded }
+	}
+	cases := []struct {
+		prefix  string
+		allowed func(byte) bool
+		keys    []string
+	}{
+		{"", except('r'), []string{"apple", "applepie", "apply", "bee"}},
+		{"a", except('r'), []string{"apple", "applepie", "apply"}},
+		{"ar", except('r'), []string{"arm"}},
+		{"appl", except('y'), []string{"apple", "applepie"}},
+		{"apple", except('i'), []string{"apple"}},
+		{"apr", except('i'), []string{}},
+		{"apr", except('r'), []string{"apricot"}},
+		{"c", except('r'), []string{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "apply", "apricot", "arm", "armor", "bee"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			if keys := tree.CompleteAllowed(c.prefix, c.allowed); !slices.Equal(keys, c.keys) {
+				t.Errorf("Case %d: CompleteAllowed(\"%s\") returned %v, expected %v.\n",
+					i, c.prefix, keys, c.keys)
+			}
+		}
+	}
+}
+
+func TestCompleteAllowedFolded(t *testing.T) {
+	lower := func(c byte) bool { return c < 'A' || c > 'Z' }
+	except := func(excluded byte) func(byte) bool {
+		return func(c byte) bool { return c != excluded }
+	}
+	cases := []struct {
+		prefix  string
+		allowed func(byte) bool
+		keys    []string
+	}{
+		{"", lower, []string{"APPLE", "ApplePie", "ARM"}},
+		{"A", lower, []string{"APPLE", "ApplePie", "ARM"}},
+		{"aP", lower, []string{"APPLE", "ApplePie"}},
+		{"ap", except('L'), []string{"APPLE", "ApplePie"}},
+		{"ap", except('l'), []string{}},
+		{"apple", except('p'), []string{"APPLE"}},
+		{"a", except('m'), []string{"APPLE", "ApplePie"}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](append(opts, WithCaseFolding())...)
+		for i, key := range []string{"APPLE", "ApplePie", "ARM"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			if keys := tree.CompleteAllowed(c.prefix, c.allowed); !slices.Equal(keys, c.keys) {
+				t.Errorf("Case %d: CompleteAllowed(\"%s\") returned %v, expected %v.\n",
+					i, c.prefix, keys, c.keys)
+			}
+		}
+	}
+}
+
+func TestFindTopN(t *testing.T) {
+	frequencies := map[string]int{
+		"apple": 50, "applepie": 20, "apply": 70, "apricot": 10,
+		"arm": 40, "armor": 30, "bee": 90, "bog": 60,
+	}
+	byFrequency := func(a, b KeyValue[int]) bool { return a.Value < b.Value }
+	cases := []struct {
+		prefix string
+		n      int
+		kvs    []KeyValue[int]
+	}{
+		{"", 3, []KeyValue[int]{{"bee", 90}, {"apply", 70}, {"bog", 60}}},
+		{"a", 2, []KeyValue[int]{{"apply", 70}, {"apple", 50}}},
+		{"appl", 10, []KeyValue[int]{{"apply", 70}, {"apple", 50}, {"applepie", 20}}},
+		{"apple", 5, []KeyValue[int]{{"apple", 50}, {"applepie", 20}}},
+		{"ar", 1, []KeyValue[int]{{"arm", 40}}},
+		{"c", 3, []KeyValue[int]{}},
+		{"", 0, []KeyValue[int]{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for key, freq := range frequencies {
+			tree.Add(key, freq)
+		}
+		for i, c := range cases {
+			if kvs := tree.FindTopN(c.prefix, c.n, byFrequency); !slices.Equal(kvs, c.kvs) {
+				t.Errorf("Case %d: FindTopN(\"%s\", %d) returned %v, expected %v.\n",
+					i, c.prefix, c.n, kvs, c.kvs)
+			}
+		}
+	}
+}
+
+func TestCountPrefix(t *testing.T) {
+	cases := []struct {
+		prefix string
+		count  int
+	}{
+		{"", 6},
+		{"a", 4},
+		{"ap", 3},
+		{"apple", 2},
+		{"applep", 1},
+		{"applepie", 1},
+		{"applepies", 0},
+		{"apply", 1},
+		{"arm", 1},
+		{"b", 2},
+		{"c", 0},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range []string{"apple", "applepie", "apply", "arm", "bee", "bog"} {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			if n := tree.CountPrefix(c.prefix); n != c.count {
+				t.Errorf("Case %d: CountPrefix(\"%s\") returned %d, expected %d.\n",
+					i, c.prefix, n, c.count)
+			}
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tree := New[int]()
+	for i, key := range []string{"a", "angle", "ant", "apple", "applepie", "arm", "bee"} {
+		tree.Add(key, i)
+	}
+
+	cases := []struct {
+		prefix  string
+		sampleN int
+		count   int
+		samples []string
+	}{
+		{"", 3, 7, []string{"a", "angle", "ant"}},
+		{"a", 0, 6, []string{}},
+		{"an", 5, 2, []string{"angle", "ant"}},
+		{"apple", 1, 2, []string{"apple"}},
+		{"applep", 2, 1, []string{"applepie"}},
+		{"b", -1, 1, []string{}},
+		{"c", 2, 0, []string{}},
+	}
+
+	for i, c := range cases {
+		count, samples := tree.Summary(c.prefix, c.sampleN)
+		if count != c.count || !slices.Equal(samples, c.samples) {
+			t.Errorf("Case %d: Summary(\"%s\", %d) returned (%d, %v), expected (%d, %v).\n",
+				i, c.prefix, c.sampleN, count, samples, c.count, c.samples)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	for _, opts := range representations {
+		for _, otherOpts := range representations {
+			tree := New[int](opts...)
+			for i, key := range []string{"apple", "applepie", "arm", "bee"} {
+				tree.Add(key, i)
+			}
+			other := New[int](otherOpts...)
+			for i, key := range []string{"a", "apply", "arm", "armor", "bog"} {
+				other.Add(key, 10+i)
+			}
+
+			tree.Merge(other)
+			expected := []KeyValue[int]{
+				{"a", 10}, {"apple", 0}, {"applepie", 1}, {"apply", 11},
+				{"arm", 12}, {"armor", 13}, {"bee", 3}, {"bog", 14},
+			}
+			if kvs := tree.FindKeyValues(""); !slices.Equal(kvs, expected) {
+				t.Errorf("Merged tree holds %v, expected %v.\n", kvs, expected)
+			}
+			if n := tree.Len(); n != len(expected) {
+				t.Errorf("Merged tree has Len() %d, expected %d.\n", n, len(expected))
+			}
+			for _, kv := range expected {
+				if value, err := tree.FindValue(kv.Key); value != kv.Value || err != nil {
+					t.Errorf("FindValue(\"%s\") returned (%d, %v), expected %d.\n",
+						kv.Key, value, err, kv.Value)
+				}
+			}
+			if n := other.Len(); n != 5 {
+				t.Errorf("Merge modified the other tree, which now has Len() %d.\n", n)
+			}
+		}
+	}
+}
+
+func TestMergeDisjoint(t *testing.T) {
+	for _, opts := range representations {
+		for _, otherOpts := range representations {
+			tree := New[int](opts...)
+			for i, key := range []string{"apple", "applepie", "cat", "dog"} {
+				tree.Add(key, i)
+			}
+			other := New[int](otherOpts...)
+			for i, key := range []string{"bee", "bog", "eel", "zebra"} {
+				other.Add(key, i+10)
+			}
+
+			if err := tree.MergeDisjoint(other); err != nil {
+				t.Fatalf("MergeDisjoint returned error: %v\n", err)
+			}
+			keys := []string{"apple", "applepie", "bee", "bog", "cat", "dog", "eel", "zebra"}
+			if k := tree.FindKeys(""); !slices.Equal(k, keys) {
+				t.Errorf("Merged tree holds %v, expected %v.\n", k, keys)
+			}
+			if v, err := tree.FindValue("z"); v != 13 || err != nil {
+				t.Errorf("FindValue(\"z\") returned (%d, %v), expected 13.\n", v, err)
+			}
+			if _, err := tree.FindValue("b"); err != ErrPrefixAmbiguous {
+				t.Errorf("FindValue(\"b\") returned %v, expected ambiguous.\n", err)
+			}
+			if n := tree.RootFanout(); n != 6 {
+				t.Errorf("Merged tree has a root fanout of %d, expected 6.\n", n)
+			}
+			if k := other.FindKeys(""); len(k) != 0 {
+				t.Errorf("Merged tree left %v in the other tree.\n", k)
+			}
+
+			// Overlapping keyspaces are rejected without modification.
+			overlap := New[int](otherOpts...)
+			overlap.Add("fish", 20)
+			overlap.Add("apricot", 21)
+			if err := tree.MergeDisjoint(overlap); err != ErrKeyspaceOverlap {
+				t.Errorf("MergeDisjoint returned %v, expected %v.\n", err, ErrKeyspaceOverlap)
+			}
+			if k := tree.FindKeys(""); !slices.Equal(k, keys) {
+				t.Errorf("Rejected merge changed the tree to %v.\n", k)
+			}
+			if k := overlap.FindKeys(""); !slices.Equal(k, []string{"apricot", "fish"}) {
+				t.Errorf("Rejected merge changed the other tree to %v.\n", k)
+			}
+		}
+	}
+}
+
+func TestMergeDisjointMultibyte(t *testing.T) {
+	// Keys whose first characters share a leading byte are disjoint.
+	cases := []struct {
+		keys, other []string
+		err         error
+	}{
+		{[]string{"éclair", "élan", "zebra"}, []string{"èze", "ça", "apple"}, nil},
+		{[]string{"éclair", "èze"}, []string{"ça", "ö"}, nil},
+		{[]string{"éclair", "èze"}, []string{"ça", "étoile"}, ErrKeyspaceOverlap},
+		{[]string{"日本", "月曜"}, []string{"旧友", "日曜"}, ErrKeyspaceOverlap},
+		{[]string{"日本", "月曜"}, []string{"旧友", "早い"}, nil},
+	}
+
+	for _, opts := range [][]Option{nil, {WithoutCompression()}, {WithRuneBoundaries()}} {
+		for i, c := range cases {
+			tree, other, fresh := New[int](opts...), New[int](opts...), New[int](opts...)
+			for j, key := range c.keys {
+				tree.Add(key, j)
+				fresh.Add(key, j)
+			}
+			for j, key := range c.other {
+				other.Add(key, j+10)
+				fresh.Add(key, j+10)
+			}
+
+			err := tree.MergeDisjoint(other)
+			if err != c.err {
+				t.Errorf("Case %d: MergeDisjoint returned %v, expected %v.\n", i, err, c.err)
+				continue
+			}
+			if err != nil {
+				if k := tree.FindKeys(""); len(k) != len(c.keys) {
+					t.Errorf("Case %d: Rejected merge changed the tree to %v.\n", i, k)
+				}
+				continue
+			}
+			if s1, s2 := tree.String(), fresh.String(); s1 != s2 {
+				t.Errorf("Case %d: MergeDisjoint produced tree:\n%s\nexpected:\n%s\n", i, s1, s2)
+			}
+			for _, key := range append(c.keys, c.other...) {
+				if v1, err := tree.FindValue(key); err != nil {
+					t.Errorf("Case %d: FindValue(%q) returned error %v.\n", i, key, err)
+				} else if v2, _ := fresh.FindValue(key); v1 != v2 {
+					t.Errorf("Case %d: FindValue(%q) returned %d, expected %d.\n", i, key, v1, v2)
+				}
+			}
+			checkCounts(t, tree)
+		}
+	}
+}
+
+func TestMatchingChars(t *testing.T) {
+	type test struct {
+		s1     string
+		s2     string
+		result int
+	}
+	var cases = []test{
+		{"a", "ap", 1},
+		{"ap", "ap", 2},
+		{"app", "ap", 2},
+		{"apple", "ap", 2},
+		{"ap", "a", 1},
+		{"apple", "a", 1},
+		{"apple", "bag", 0},
+	}
+	for i, c := range cases {
+		r := matchingChars(c.s1, c.s2)
+		if r != c.result {
+			t.Errorf("Case %d: matchingChars(\"%s\", \"%s\") returned %d, expected %d\n",
+				i, c.s1, c.s2, r, c.result)
+		}
+	}
+}
+
+func TestDictionary(t *testing.T) {
+	// Attempt to open the unix words dictionary file. If it doesn't
+	// exist, skip this test.
+	file, err := os.Open("/usr/share/dict/words")
+	if err != nil {
+		return
+	}
+
+	// Scan all words from the dictionary into the tree.
+	tree := New[bool]()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		tree.Add(scanner.Text(), true)
+	}
+	file.Close()
 
 	// Find some prefixes that should be unambiguous and in the
 	// dictionary.
@@ -417,3 +2863,96 @@ func BenchmarkDictionary(b *testing.B) {
 		}
 	}
 }
+
+// benchmarkKeys returns n pseudo-random lowercase keys for use by
+// benchmarks that shouldn't depend on the unix words dictionary.
+func benchmarkKeys(n int) []string {
+	rng := rand.New(rand.NewSource(1))
+	keys := make([]string, n)
+	for i := range keys {
+		b := make([]byte, 3+rng.Intn(10))
+		for j := range b {
+			b[j] = byte('a' + rng.Intn(26))
+		}
+		keys[i] = string(b)
+	}
+	return keys
+}
+
+func benchmarkAdd(b *testing.B, opts ...Option) {
+	keys := benchmarkKeys(50000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree := New[int](opts...)
+		for j, key := range keys {
+			tree.Add(key, j)
+		}
+	}
+}
+
+func benchmarkFind(b *testing.B, opts ...Option) {
+	keys := benchmarkKeys(50000)
+	tree := New[int](opts...)
+	for j, key := range keys {
+		tree.Add(key, j)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range keys[:1000] {
+			tree.FindValue(key)
+		}
+	}
+}
+
+func BenchmarkAddSorted(b *testing.B) {
+	keys := benchmarkKeys(50000)
+	slices.Sort(keys)
+	kvs := make([]KeyValue[int], len(keys))
+	for i, key := range keys {
+		kvs[i] = KeyValue[int]{key, i}
+	}
+	b.Run("Add", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree := New[int]()
+			for _, kv := range kvs {
+				tree.Add(kv.Key, kv.Value)
+			}
+		}
+	})
+	b.Run("AddSorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			New[int]().AddSorted(kvs)
+		}
+	})
+}
+
+func BenchmarkLinearSearchCutoff(b *testing.B) {
+	// Keys drawn from all 256 byte values give the upper nodes of the tree
+	// hundreds of links each.
+	rng := rand.New(rand.NewSource(1))
+	keys := make([]string, 50000)
+	for i := range keys {
+		k := make([]byte, 3+rng.Intn(6))
+		rng.Read(k)
+		keys[i] = string(k)
+	}
+
+	for _, cutoff := range []int{1, 8, 20, 64, 256} {
+		tree := New[int](WithLinearSearchCutoff(cutoff))
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		b.Run(fmt.Sprintf("Cutoff%d", cutoff), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, key := range keys[:1000] {
+					tree.FindValue(key)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAddCompressed(b *testing.B)    { benchmarkAdd(b) }
+func BenchmarkAddUncompressed(b *testing.B)  { benchmarkAdd(b, WithoutCompression()) }
+func BenchmarkFindCompressed(b *testing.B)   { benchmarkFind(b) }
+func BenchmarkFindUncompressed(b *testing.B) { benchmarkFind(b, WithoutCompression()) }
@@ -0,0 +1,234 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"sort"
+	"strings"
+)
+
+// FindAnagrams returns all keys in the prefix tree that are anagrams of
+// letters, that is, keys consisting of exactly the bytes in letters in any
+// order. Repeated letters must appear in a key as many times as they appear
+// in letters. In a tree with a splitter, keys are anagrams of letters if
+// they consist of exactly the tokens of letters in any order. The search only
+// descends into links whose bytes are still available, so it visits a small
+// fraction of the tree. The keys are returned in lexicographic order.
+func (t *Tree[V]) FindAnagrams(letters string) []string {
+	tokens := t.opts.isSplitting()
+	symbols := pathSymbols(t.opts.fold(letters), tokens)
+	counts := make(map[string]int, len(symbols))
+	for _, sym := range symbols {
+		counts[sym]++
+	}
+	return appendAnagrams(t, tokens, counts, len(symbols), "", []string{})
+}
+
+// appendAnagrams recursively appends to keys the terminal descendants of a
+// tree that use up exactly the remaining symbol counts. The path to the tree
+// ends with partial, the incomplete start of a symbol.
+func appendAnagrams[V any](t *Tree[V], tokens bool, counts map[string]int, remaining int, partial string, keys []string) []string {
+	if remaining == 0 {
+		if t.isTerminal() {
+			keys = append(keys, t.key)
+		}
+		return keys
+	}
+
+	for i := 0; i < len(t.links); i++ {
+		l := &t.links[i]
+
+		// Consume the symbols completed along the link's key segment,
+		// backing out if any of them isn't available.
+		seg, sym, n, ok := l.keyseg, partial, 0, true
+		for len(seg) > 0 {
+			m, complete := nextSymbol(seg, tokens)
+			sym, seg = sym+seg[:m], seg[m:]
+			if !complete {
+				break
+			}
+			if counts[sym] == 0 {
+				ok = false
+				break
+			}
+			counts[sym]--
+			sym, n = "", n+1
+		}
+		if ok {
+			keys = appendAnagrams(l.tree, tokens, counts, remaining-n, sym, keys)
+		}
+		for seg, sym = l.keyseg, partial; n > 0; n-- {
+			m, _ := nextSymbol(seg, tokens)
+			counts[sym+seg[:m]]++
+			sym, seg = "", seg[m:]
+		}
+	}
+	return keys
+}
+
+// SuggestCorrections returns up to limit keys in the prefix tree that are
+// within maxDist edits of word, ordered by increasing edit distance and then
+// lexicographically. Distances are Levenshtein distances counted in bytes,
+// or in tokens in a tree with a splitter. The search computes one row of the
+// edit distance table per byte or token of each link followed, abandoning links as soon as every entry of the row exceeds
+// maxDist, and keeps only the best limit keys found so far. If limit is zero
+// or negative, all keys within maxDist are returned.
+func (t *Tree[V]) SuggestCorrections(word string, maxDist, limit int) []string {
+	type suggestion struct {
+		key  string
+		dist int
+	}
+	worse := func(a, b suggestion) bool {
+		return a.dist > b.dist || (a.dist == b.dist && a.key > b.key)
+	}
+
+	var found []suggestion
+	var h *topN[suggestion]
+	if limit > 0 {
+		h = newTopN(limit, worse)
+	}
+	searchEditDistance(t, word, maxDist, func(n *Tree[V], dist int) {
+		if h != nil {
+			h.add(suggestion{n.key, dist})
+		} else {
+			found = append(found, suggestion{n.key, dist})
+		}
+	})
+	if h != nil {
+		found = h.sorted()
+	} else {
+		sort.SliceStable(found, func(i, j int) bool { return found[i].dist < found[j].dist })
+	}
+
+	keys := make([]string, len(found))
+	for i, s := range found {
+		keys[i] = s.key
+	}
+	return keys
+}
+
+// FindFuzzy returns the keys and values of all keys in the prefix tree that
+// are within maxDist edits of query, ordered by increasing edit distance and
+// then lexicographically. Distances are Levenshtein distances counted in
+// bytes, or in tokens in a tree with a splitter. As with SuggestCorrections,
+// the search computes one row of the edit distance table per byte or token
+// of each link followed and abandons a link as soon as no key beneath it can
+// be within maxDist of query, so small distances such as 1 or 2 visit only a
+// small part of the tree.
+func (t *Tree[V]) FindFuzzy(query string, maxDist int) []KeyValue[V] {
+	type match struct {
+		kv   KeyValue[V]
+		dist int
+	}
+	var found []match
+	searchEditDistance(t, query, maxDist, func(n *Tree[V], dist int) {
+		found = append(found, match{KeyValue[V]{n.key, n.value}, dist})
+	})
+	sort.SliceStable(found, func(i, j int) bool { return found[i].dist < found[j].dist })
+
+	kvs := make([]KeyValue[V], len(found))
+	for i, m := range found {
+		kvs[i] = m.kv
+	}
+	return kvs
+}
+
+// searchEditDistance calls fn, in lexicographic key order, for each terminal
+// node of a tree whose key is within maxDist edits of word, along with the
+// key's edit distance.
+func searchEditDistance[V any](t *Tree[V], word string, maxDist int, fn func(n *Tree[V], dist int)) {
+	if maxDist < 0 {
+		return
+	}
+	tokens := t.opts.isSplitting()
+	symbols := pathSymbols(t.opts.fold(word), tokens)
+	s := editDistanceSearch[V]{word: symbols, tokens: tokens, maxDist: maxDist, fn: fn}
+	s.rows = [][]int{make([]int, len(symbols)+1)}
+	for i := range s.rows[0] {
+		s.rows[0][i] = i
+	}
+	s.search(t, 0, "")
+}
+
+// An editDistanceSearch walks a tree while maintaining the table of edit
+// distances between the symbols of the path walked so far and those of a
+// word.
+type editDistanceSearch[V any] struct {
+	word    []string
+	tokens  bool // whether symbols are tokens rather than bytes
+	maxDist int
+	rows    [][]int // rows[d][i] is the distance from d path symbols to word[:i]
+	fn      func(n *Tree[V], dist int)
+}
+
+// search recursively searches a tree found at the given depth in symbols,
+// whose path ends with partial, the incomplete start of a symbol.
+func (s *editDistanceSearch[V]) search(t *Tree[V], depth int, partial string) {
+	if dist := s.rows[depth][len(s.word)]; t.isTerminal() && dist <= s.maxDist {
+		s.fn(t, dist)
+	}
+
+outerLoop:
+	for i := 0; i < len(t.links); i++ {
+		seg, d, sym := t.links[i].keyseg, depth, partial
+		for len(seg) > 0 {
+			m, complete := nextSymbol(seg, s.tokens)
+			sym, seg = sym+seg[:m], seg[m:]
+			if !complete {
+				break
+			}
+			d++
+			if s.computeRow(d, sym) > s.maxDist {
+				continue outerLoop
+			}
+			sym = ""
+		}
+		s.search(t.links[i].tree, d, sym)
+	}
+}
+
+// computeRow computes the row of the edit distance table at depth d, whose
+// last path symbol is sym, and returns the smallest distance in the row.
+func (s *editDistanceSearch[V]) computeRow(d int, sym string) int {
+	if d == len(s.rows) {
+		s.rows = append(s.rows, make([]int, len(s.word)+1))
+	}
+	prev, row := s.rows[d-1], s.rows[d]
+	row[0] = d
+	least := d
+	for i := 1; i <= len(s.word); i++ {
+		cost := 1
+		if s.word[i-1] == sym {
+			cost = 0
+		}
+		row[i] = min(prev[i]+1, row[i-1]+1, prev[i-1]+cost)
+		least = min(least, row[i])
+	}
+	return least
+}
+
+// nextSymbol returns the length of the first symbol of s, a piece of a
+// folded path, as compared by FindAnagrams and the edit distance searches,
+// and whether s holds all of it. Symbols are single bytes, or encoded tokens
+// ending with their zero bytes if tokens is true.
+func nextSymbol(s string, tokens bool) (n int, complete bool) {
+	if !tokens {
+		return 1, true
+	}
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		return i + 1, true
+	}
+	return len(s), false
+}
+
+// pathSymbols divides a folded path into its symbols.
+func pathSymbols(s string, tokens bool) []string {
+	var symbols []string
+	for len(s) > 0 {
+		n, _ := nextSymbol(s, tokens)
+		symbols, s = append(symbols, s[:n]), s[n:]
+	}
+	return symbols
+}
@@ -0,0 +1,112 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFindAnagrams(t *testing.T) {
+	keys := []string{
+		"act", "arc", "car", "cat", "cart", "scar", "arcs", "cars", "tac", "a",
+		"aa", "baa", "aba", "ab",
+	}
+	cases := []struct {
+		letters string
+		keys    []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{"aa", []string{"aa"}},
+		{"tca", []string{"act", "cat", "tac"}},
+		{"rac", []string{"arc", "car"}},
+		{"rcas", []string{"arcs", "cars", "scar"}},
+		{"aab", []string{"aba", "baa"}},
+		{"abb", []string{}},
+		{"xyz", []string{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			anagrams := tree.FindAnagrams(c.letters)
+			if !slices.Equal(anagrams, c.keys) {
+				t.Errorf("Case %d: FindAnagrams(\"%s\") returned %v, expected %v.\n",
+					i, c.letters, anagrams, c.keys)
+			}
+		}
+	}
+}
+
+func TestSuggestCorrections(t *testing.T) {
+	keys := []string{
+		"apple", "apply", "ample", "maple", "applet", "apples", "angle", "ape",
+		"banana", "bandana", "cabana",
+	}
+	cases := []struct {
+		word    string
+		maxDist int
+		limit   int
+		keys    []string
+	}{
+		{"aple", 1, 10, []string{"ample", "ape", "apple", "maple"}},
+		{"aple", 1, 2, []string{"ample", "ape"}},
+		{"apple", 0, 5, []string{"apple"}},
+		{"apple", 1, 0, []string{"apple", "ample", "apples", "applet", "apply"}},
+		{"banana", 2, 5, []string{"banana", "bandana", "cabana"}},
+		{"xyz", 2, 5, []string{}},
+		{"apple", -1, 5, []string{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			result := tree.SuggestCorrections(c.word, c.maxDist, c.limit)
+			if !slices.Equal(result, c.keys) {
+				t.Errorf("Case %d: SuggestCorrections(\"%s\", %d, %d) returned %v, expected %v.\n",
+					i, c.word, c.maxDist, c.limit, result, c.keys)
+			}
+		}
+	}
+}
+
+func TestFindFuzzy(t *testing.T) {
+	keys := []string{"apple", "apply", "ample", "maple", "applet", "ape", "banana"}
+	cases := []struct {
+		query   string
+		maxDist int
+		kvs     []KeyValue[int]
+	}{
+		{"aple", 1, []KeyValue[int]{{"ample", 2}, {"ape", 5}, {"apple", 0}, {"maple", 3}}},
+		{"apple", 0, []KeyValue[int]{{"apple", 0}}},
+		{"apple", 1, []KeyValue[int]{{"apple", 0}, {"ample", 2}, {"applet", 4}, {"apply", 1}}},
+		{"appl", 2, []KeyValue[int]{
+			{"apple", 0}, {"apply", 1},
+			{"ample", 2}, {"ape", 5}, {"applet", 4},
+		}},
+		{"xyz", 2, []KeyValue[int]{}},
+		{"apple", -1, []KeyValue[int]{}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			if kvs := tree.FindFuzzy(c.query, c.maxDist); !slices.Equal(kvs, c.kvs) {
+				t.Errorf("Case %d: FindFuzzy(\"%s\", %d) returned %v, expected %v.\n",
+					i, c.query, c.maxDist, kvs, c.kvs)
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+// A Set is a prefix tree that stores keys without values, making it a set of
+// strings searchable by prefix. It is built on a Tree[struct{}], whose nodes
+// have no room for values, and shares all of its behavior.
+type Set struct {
+	tree *Tree[struct{}]
+}
+
+// NewSet returns an empty set prefix tree. Options may be provided to
+// configure the underlying tree.
+func NewSet(opts ...Option) *Set {
+	return &Set{tree: New[struct{}](opts...)}
+}
+
+// Add a key string to the set. Adding a key already in the set has no
+// effect.
+func (s *Set) Add(key string) {
+	s.tree.Add(key, struct{}{})
+}
+
+// Contains returns true if the key exactly matches a key in the set, as
+// Tree.Contains does.
+func (s *Set) Contains(key string) bool {
+	return s.tree.Contains(key)
+}
+
+// FindKeys searches the set for all key strings prefixed by the provided
+// prefix and returns them, as Tree.FindKeys does.
+func (s *Set) FindKeys(prefix string) []string {
+	return s.tree.FindKeys(prefix)
+}
+
+// Delete removes a key from the set, returning false if the key isn't in
+// it.
+func (s *Set) Delete(key string) bool {
+	return s.tree.Delete(key)
+}
+
+// Len returns the number of keys in the set.
+func (s *Set) Len() int {
+	return s.tree.Len()
+}
@@ -0,0 +1,57 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	cases := []struct {
+		prefix   string
+		keys     []string
+		contains bool
+	}{
+		{"", []string{"apple", "applepie", "arm", "bee"}, false},
+		{"a", []string{"apple", "applepie", "arm"}, false},
+		{"app", []string{"apple", "applepie"}, false},
+		{"apple", []string{"apple"}, true},
+		{"applepie", []string{"applepie"}, true},
+		{"ar", []string{"arm"}, false},
+		{"bee", []string{"bee"}, true},
+		{"c", []string{}, false},
+	}
+
+	for _, opts := range representations {
+		s := NewSet(opts...)
+		for _, key := range []string{"apple", "applepie", "arm", "bee", "apple"} {
+			s.Add(key)
+		}
+		for i, c := range cases {
+			if keys := s.FindKeys(c.prefix); !slices.Equal(keys, c.keys) {
+				t.Errorf("Case %d: FindKeys(\"%s\") returned %v, expected %v.\n",
+					i, c.prefix, keys, c.keys)
+			}
+			if contains := s.Contains(c.prefix); contains != c.contains {
+				t.Errorf("Case %d: Contains(\"%s\") returned %v, expected %v.\n",
+					i, c.prefix, contains, c.contains)
+			}
+		}
+		if n := s.Len(); n != 4 {
+			t.Errorf("Len() returned %d, expected 4.\n", n)
+		}
+
+		if !s.Delete("apple") || s.Delete("apple") {
+			t.Errorf("Delete(\"apple\") failed.\n")
+		}
+		if keys := s.FindKeys("app"); !slices.Equal(keys, []string{"applepie"}) {
+			t.Errorf("FindKeys(\"app\") after Delete returned %v, expected [applepie].\n", keys)
+		}
+		if s.Contains("apple") || s.Len() != 3 {
+			t.Errorf("Set still holds \"apple\" after Delete.\n")
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// HeaviestPrefixes returns up to n of the prefix tree's branch points paired
+// with the number of keys beneath them, heaviest first. A branch point is any
+// node below the root from which more than one link leaves, or from which a
+// link leaves a stored key; its prefix is the path of key segments leading to
+// it. In a tree with a splitter, keys branch between tokens, and the prefix
+// of a branch point is the prefix of its first key holding the tokens they
+// share. Prefixes with equal counts are ordered lexicographically. This
+// shows where the keys of the tree cluster, for instance to decide where
+// sharding the keyspace would help.
+func (t *Tree[V]) HeaviestPrefixes(n int) []KeyValue[int] {
+	if n <= 0 {
+		return []KeyValue[int]{}
+	}
+	h := newTopN(n, func(a, b KeyValue[int]) bool {
+		return a.Value < b.Value || (a.Value == b.Value && a.Key > b.Key)
+	})
+	if t.opts.isSplitting() {
+		addHeaviestTokenPrefixes(t, t.opts, "", 0, 0, new(bool), h)
+		return h.sorted()
+	}
+	for i := 0; i < len(t.links); i++ {
+		addHeaviestPrefixes(t.links[i].tree, t.links[i].keyseg, h)
+	}
+	return h.sorted()
+}
+
+// addHeaviestPrefixes recursively offers the prefixes of a tree's branch
+// points to a top-n collection.
+func addHeaviestPrefixes[V any](t *Tree[V], prefix string, h *topN[KeyValue[int]]) {
+	if len(t.links) > 1 || (len(t.links) == 1 && t.isTerminal()) {
+		h.add(KeyValue[int]{prefix, t.descendants})
+	}
+	for i := 0; i < len(t.links); i++ {
+		addHeaviestPrefixes(t.links[i].tree, prefix+t.links[i].keyseg, h)
+	}
+}
+
+// addHeaviestTokenPrefixes recursively offers the prefixes of the branch
+// points of a tree with a splitter to a top-n collection. The folded path to
+// the tree begins with the b bytes of the tokens its keys share with the
+// count keys beneath them, and reported is shared by all the trees whose
+// paths end within the next token, so that the prefix is offered only once.
+func addHeaviestTokenPrefixes[V any](t *Tree[V], o *options, path string, b, count int, reported *bool, h *topN[KeyValue[int]]) {
+	if b > 0 && !*reported && (len(t.links) > 1 || (len(t.links) == 1 && t.isTerminal())) {
+		*reported = true
+		key := firstDescendant(t).key
+		h.add(KeyValue[int]{key[:o.keyPrefixLen(key, o.fold(key), b)], count})
+	}
+	for i := 0; i < len(t.links); i++ {
+		l := &t.links[i]
+		if j := strings.LastIndexByte(l.keyseg, 0); j >= 0 {
+			addHeaviestTokenPrefixes(l.tree, o, path+l.keyseg, len(path)+j+1, l.tree.descendants, new(bool), h)
+		} else {
+			addHeaviestTokenPrefixes(l.tree, o, path+l.keyseg, b, count, reported, h)
+		}
+	}
+}
+
+// TopNPerBranch groups the keys of the prefix tree by the key segment of the
+// root link they descend from, and returns the n greatest entries of each
+// group according to less, greatest first. In a compressed tree the grouping
+// segment is the longest prefix shared by all keys of the group; in an
+// uncompressed tree it is the first byte of each key, or the first character
+// in a tree created with WithRuneBoundaries. In a tree with a splitter, the
+// segments are shown as String shows them. Each group is collected with its
+// own bounded heap in a single walk of the tree, so no group is ever fully
+// sorted.
+func (t *Tree[V]) TopNPerBranch(n int, less func(a, b KeyValue[V]) bool) map[string][]KeyValue[V] {
+	groups := make(map[string][]KeyValue[V], len(t.links))
+	for i := 0; i < len(t.links); i++ {
+		h := newTopN(n, less)
+		walkDescendants(t.links[i].tree, func(n *Tree[V]) bool {
+			h.add(KeyValue[V]{n.key, n.value})
+			return true
+		})
+		label, _ := t.opts.segmentLabel(t.links[i].keyseg, false)
+		groups[label] = h.sorted()
+	}
+	return groups
+}
+
+// UniquePrefixLengths returns, for each key in the prefix tree, the length of
+// the shortest prefix of the key that the Find methods resolve uniquely to
+// it. This is the minimum number of bytes a user must type to select the key.
+// Keys that no prefix resolves to, such as keys that prefix other keys under
+// the TerminalAmbiguous mode, are omitted. All lengths are computed in a
+// single walk of the tree.
+func (t *Tree[V]) UniquePrefixLengths() map[string]int {
+	lengths := make(map[string]int)
+	addUniquePrefixLengths(t, 0, t.opts, lengths)
+
+	// The lengths found so far measure the keys' folded forms. A prefix
+	// ending partway through a token matches nothing in a tree with a
+	// splitter, so extend it to the end of the token, and then find the
+	// length of the key's own prefix with that folded form.
+	for key, n := range lengths {
+		path := t.opts.fold(key)
+		if t.opts.isSplitting() {
+			n += strings.IndexByte(path[n-1:], 0)
+		}
+		lengths[key] = t.opts.keyPrefixLen(key, path, n)
+	}
+	return lengths
+}
+
+// addUniquePrefixLengths recursively records the unique prefix lengths of
+// the keys beneath a tree found at the given depth in bytes.
+func addUniquePrefixLengths[V any](t *Tree[V], depth int, o *options, lengths map[string]int) {
+	for i := 0; i < len(t.links); i++ {
+		child, seg := t.links[i].tree, t.links[i].keyseg
+
+		// A prefix reaching into the link of a subtree holding a single key
+		// resolves to that key, so the first character of the link suffices.
+		if child.descendants == 1 {
+			for !child.isTerminal() {
+				child = child.links[0].tree
+			}
+			lengths[child.key] = depth + o.charLen(seg)
+			continue
+		}
+
+		// Otherwise, a prefix must reach all the way to a terminal child to
+		// resolve to it, unless that counts as ambiguous.
+		if child.isTerminal() && !o.isTerminalAmbiguous() {
+			lengths[child.key] = depth + len(seg)
+		}
+		addUniquePrefixLengths(child, depth+len(seg), o, lengths)
+	}
+}
+
+// Stats describes the shape of a prefix tree, as reported by Tree.Stats.
+type Stats struct {
+	NodeCount     int     // nodes in the tree, including the root
+	TerminalCount int     // nodes holding keys, which is the number of keys
+	MaxDepth      int     // most links between the root and a node
+	AverageDepth  float64 // mean number of links between the root and a key
+}
+
+// Stats measures the shape of the prefix tree in a single walk. Comparing the
+// average depth of keys with the maximum depth shows how balanced the tree
+// is, and a depth approaching the length of the keys reveals long chains of
+// single-link nodes.
+func (t *Tree[V]) Stats() Stats {
+	var s Stats
+	depthSum := 0
+	var measure func(n *Tree[V], depth int)
+	measure = func(n *Tree[V], depth int) {
+		s.NodeCount++
+		s.MaxDepth = max(s.MaxDepth, depth)
+		if n.isTerminal() {
+			s.TerminalCount++
+			depthSum += depth
+		}
+		for i := 0; i < len(n.links); i++ {
+			measure(n.links[i].tree, depth+1)
+		}
+	}
+	measure(t, 0)
+
+	if s.TerminalCount > 0 {
+		s.AverageDepth = float64(depthSum) / float64(s.TerminalCount)
+	}
+	return s
+}
+
+// DepthOf returns the number of links followed from the root of the prefix
+// tree to reach a stored key, which is the number of steps the Find methods
+// take to resolve the key exactly. It returns -1 if the key isn't stored in
+// the tree. Comparing the depths of keys with the MaxDepth and AverageDepth
+// reported by Stats shows which keys lie at the end of unusually long chains.
+func (t *Tree[V]) DepthOf(key string) int {
+	depth := 0
+	for k := t.opts.fold(key); len(k) > 0; depth++ {
+		ix := t.prefixLink(k)
+		if ix < 0 {
+			return -1
+		}
+		link := &t.links[ix]
+		t, k = link.tree, k[len(link.keyseg):]
+	}
+	if !t.isTerminal() {
+		return -1
+	}
+	return depth
+}
+
+// A TreeComparison describes the structure of two prefix trees side by side,
+// as reported by CompareTrees.
+type TreeComparison struct {
+	NodesA, NodesB               int  // nodes in each tree, including the root
+	MaxDepthA, MaxDepthB         int  // most links between the root and a node
+	SegmentBytesA, SegmentBytesB int  // total bytes of all link key segments
+	SameKeys                     bool // whether the trees hold the same keys
+}
+
+// CompareTrees compares the structure and key sets of two prefix trees. It is
+// useful for checking that a rebuild of a tree changed its shape without
+// changing the keys it holds. Values are not compared.
+func CompareTrees[V any](a, b *Tree[V]) TreeComparison {
+	var c TreeComparison
+	c.NodesA, c.MaxDepthA, c.SegmentBytesA = measureShape(a, 0)
+	c.NodesB, c.MaxDepthB, c.SegmentBytesB = measureShape(b, 0)
+
+	keys, i := appendDescendantKeys(a, nil), 0
+	c.SameKeys = walkDescendants(b, func(n *Tree[V]) bool {
+		if i == len(keys) || keys[i] != n.key {
+			return false
+		}
+		i++
+		return true
+	}) && i == len(keys)
+	return c
+}
+
+// measureShape recursively measures the number of nodes, the maximum depth
+// and the total key segment bytes of a tree found at the given depth.
+func measureShape[V any](t *Tree[V], depth int) (nodes, maxDepth, segBytes int) {
+	nodes, maxDepth = 1, depth
+	for i := 0; i < len(t.links); i++ {
+		n, d, b := measureShape(t.links[i].tree, depth+1)
+		nodes, maxDepth = nodes+n, max(maxDepth, d)
+		segBytes += b + len(t.links[i].keyseg)
+	}
+	return nodes, maxDepth, segBytes
+}
+
+// EstimatedSize returns an approximate number of bytes of memory used by the
+// prefix tree: the sizes of its nodes and of the arrays backing their links,
+// and the string data of its keys and of any key segments not sharing memory
+// with the keys. Memory that values refer to, such as the contents of string
+// or slice values, isn't counted unless the tree was created with the
+// WithValueSizer option. Allocator overhead and unused capacity in string
+// data aren't counted either, so the true footprint is somewhat larger.
+func (t *Tree[V]) EstimatedSize() int64 {
+	var size int64
+	var sizer func(V) int64
+	if t.opts != nil {
+		size = int64(unsafe.Sizeof(*t.opts))
+		sizer, _ = t.opts.valueSizer.(func(V) int64)
+	}
+	transformed := t.opts.isCaseFolding() || t.opts.isSplitting()
+	return size + estimateSize(t, sizer, transformed)
+}
+
+// estimateSize recursively estimates the number of bytes of memory used by a
+// tree. Key segments are counted only if they are transformed copies of the
+// keys rather than substrings of them.
+func estimateSize[V any](t *Tree[V], sizer func(V) int64, transformed bool) int64 {
+	size := int64(unsafe.Sizeof(*t)) + int64(cap(t.links))*int64(unsafe.Sizeof(link[V]{}))
+	if t.isTerminal() {
+		size += int64(len(t.key))
+		if sizer != nil {
+			size += sizer(t.value)
+		}
+	}
+	for i := 0; i < len(t.links); i++ {
+		if transformed {
+			size += int64(len(t.links[i].keyseg))
+		}
+		size += estimateSize(t.links[i].tree, sizer, transformed)
+	}
+	return size
+}
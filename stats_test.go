@@ -0,0 +1,328 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestHeaviestPrefixes(t *testing.T) {
+	keys := []string{"apple", "applepie", "apricot", "arm", "armor", "bee", "bog"}
+	cases := []struct {
+		n        int
+		prefixes []KeyValue[int]
+	}{
+		{0, []KeyValue[int]{}},
+		{1, []KeyValue[int]{{"a", 5}}},
+		{3, []KeyValue[int]{{"a", 5}, {"ap", 3}, {"apple", 2}}},
+		{10, []KeyValue[int]{{"a", 5}, {"ap", 3}, {"apple", 2}, {"arm", 2}, {"b", 2}}},
+	}
+
+	for _, opts := range representations {
+		tree := New[int](opts...)
+		for i, key := range keys {
+			tree.Add(key, i)
+		}
+		for i, c := range cases {
+			prefixes := tree.HeaviestPrefixes(c.n)
+			if !slices.Equal(prefixes, c.prefixes) {
+				t.Errorf("Case %d: HeaviestPrefixes(%d) returned %v, expected %v.\n",
+					i, c.n, prefixes, c.prefixes)
+			}
+		}
+	}
+}
+
+func TestTopNPerBranch(t *testing.T) {
+	entries := []entry{
+		{"apple", 5},
+		{"applepie", 9},
+		{"apricot", 2},
+		{"arm", 7},
+		{"bee", 1},
+		{"bog", 3},
+		{"cat", 4},
+	}
+	tree := New[int]()
+	for _, e := range entries {
+		tree.Add(e.key, e.value)
+	}
+
+	less := func(a, b KeyValue[int]) bool { return a.Value < b.Value }
+	cases := []struct {
+		n      int
+		groups map[string][]KeyValue[int]
+	}{
+		{0, map[string][]KeyValue[int]{"a": {}, "b": {}, "cat": {}}},
+		{1, map[string][]KeyValue[int]{
+			"a":   {{"applepie", 9}},
+			"b":   {{"bog", 3}},
+			"cat": {{"cat", 4}},
+		}},
+		{3, map[string][]KeyValue[int]{
+			"a":   {{"applepie", 9}, {"arm", 7}, {"apple", 5}},
+			"b":   {{"bog", 3}, {"bee", 1}},
+			"cat": {{"cat", 4}},
+		}},
+	}
+
+	for i, c := range cases {
+		groups := tree.TopNPerBranch(c.n, less)
+		if len(groups) != len(c.groups) {
+			t.Errorf("Case %d: TopNPerBranch(%d) returned %v, expected %v.\n",
+				i, c.n, groups, c.groups)
+			continue
+		}
+		for seg, expected := range c.groups {
+			if !slices.Equal(groups[seg], expected) {
+				t.Errorf("Case %d: TopNPerBranch(%d)[\"%s\"] is %v, expected %v.\n",
+					i, c.n, seg, groups[seg], expected)
+			}
+		}
+	}
+}
+
+func TestUniquePrefixLengths(t *testing.T) {
+	keys := []string{"a", "apple", "applepie", "apricot", "arm", "armor", "bee", "cat", "cattle"}
+	expected := map[TerminalPrefixMode]map[string]int{
+		TerminalWins: {
+			"a": 1, "apple": 5, "applepie": 6, "apricot": 3, "arm": 3,
+			"armor": 4, "bee": 1, "cat": 3, "cattle": 4,
+		},
+		TerminalAmbiguous: {
+			"applepie": 6, "apricot": 3, "armor": 4, "bee": 1, "cattle": 4,
+		},
+	}
+
+	for _, opts := range representations {
+		for mode, lengths := range expected {
+			tree := New[int](opts...)
+			tree.SetTerminalPrefixMode(mode)
+			for i, key := range keys {
+				tree.Add(key, i)
+			}
+
+			result := tree.UniquePrefixLengths()
+			if len(result) != len(lengths) {
+				t.Errorf("Mode %d: UniquePrefixLengths() returned %v, expected %v.\n",
+					mode, result, lengths)
+				continue
+			}
+
+			// Each length must agree with the shortest prefix FindKey
+			// resolves to the key.
+			for key, n := range lengths {
+				if result[key] != n {
+					t.Errorf("Mode %d: UniquePrefixLengths()[\"%s\"] is %d, expected %d.\n",
+						mode, key, result[key], n)
+				}
+				for i := 1; i <= len(key); i++ {
+					if k, err := tree.FindKey(key[:i]); err == nil && k == key {
+						if i != n {
+							t.Errorf("Mode %d: FindKey(\"%s\") resolves to %q, but the expected length is %d.\n",
+								mode, key[:i], key, n)
+						}
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestUniquePrefixLengthsFolded(t *testing.T) {
+	dots := func(s string) []string { return strings.Split(s, ".") }
+	cases := []struct {
+		opts    []Option
+		keys    []string
+		lengths map[string]int
+	}{
+		{
+			[]Option{WithCaseFolding()},
+			[]string{"\u212Aelvin", "KELP", "Apple"},
+			map[string]int{"\u212Aelvin": 6, "KELP": 4, "Apple": 1},
+		},
+		{
+			[]Option{WithCaseFolding()},
+			[]string{"\u212Aelvin", "Apple"},
+			map[string]int{"\u212Aelvin": 3, "Apple": 1},
+		},
+		{
+			[]Option{WithCaseFolding(), WithRuneBoundaries()},
+			[]string{"日本", "日光", "Apple"},
+			map[string]int{"日本": 6, "日光": 6, "Apple": 1},
+		},
+		{
+			[]Option{WithSplitter(dots)},
+			[]string{"a.bc.d", "a.bd", "x.y"},
+			map[string]int{"a.bc.d": 4, "a.bd": 4, "x.y": 1},
+		},
+		{
+			[]Option{WithSplitter(dots), WithCaseFolding()},
+			[]string{"A.B", "A.B.C", "\u212A.x"},
+			map[string]int{"A.B": 3, "A.B.C": 5, "\u212A.x": 3},
+		},
+	}
+
+	for i, c := range cases {
+		tree := New[int](c.opts...)
+		for j, key := range c.keys {
+			tree.Add(key, j)
+		}
+
+		result := tree.UniquePrefixLengths()
+		if !maps.Equal(result, c.lengths) {
+			t.Errorf("Case %d: UniquePrefixLengths() returned %v, expected %v.\n", i, result, c.lengths)
+		}
+		for key, n := range c.lengths {
+			if k, err := tree.FindKey(key[:n]); k != key || err != nil {
+				t.Errorf("Case %d: FindKey(%q) returned (%q, %v), expected %q.\n", i, key[:n], k, err, key)
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	cases := []struct {
+		opts  []Option
+		stats Stats
+	}{
+		{nil, Stats{NodeCount: 6, TerminalCount: 4, MaxDepth: 3, AverageDepth: 2}},
+		{[]Option{WithoutCompression()}, Stats{NodeCount: 14, TerminalCount: 4, MaxDepth: 8, AverageDepth: 4.75}},
+	}
+
+	for i, c := range cases {
+		tree := New[int](c.opts...)
+		for j, key := range []string{"apple", "applepie", "arm", "bee"} {
+			tree.Add(key, j)
+		}
+		if s := tree.Stats(); s != c.stats {
+			t.Errorf("Case %d: Stats() returned %+v, expected %+v.\n", i, s, c.stats)
+		}
+	}
+
+	if s := New[int]().Stats(); s != (Stats{NodeCount: 1}) {
+		t.Errorf("Stats() on an empty tree returned %+v.\n", s)
+	}
+}
+
+func TestDepthOf(t *testing.T) {
+	cases := []struct {
+		key                      string
+		compressed, uncompressed int
+	}{
+		{"a", 1, 1},
+		{"apple", 3, 5},
+		{"applepie", 4, 8},
+		{"apricot", 3, 7},
+		{"bee", 1, 3},
+		{"", -1, -1},
+		{"ap", -1, -1},
+		{"appl", -1, -1},
+		{"applepies", -1, -1},
+		{"cat", -1, -1},
+	}
+
+	compressed, uncompressed := New[int](), New[int](WithoutCompression())
+	for i, key := range []string{"a", "apple", "applepie", "apricot", "bee"} {
+		compressed.Add(key, i)
+		uncompressed.Add(key, i)
+	}
+	for i, c := range cases {
+		if d := compressed.DepthOf(c.key); d != c.compressed {
+			t.Errorf("Case %d: DepthOf(\"%s\") returned %d, expected %d.\n", i, c.key, d, c.compressed)
+		}
+		if d := uncompressed.DepthOf(c.key); d != c.uncompressed {
+			t.Errorf("Case %d: uncompressed DepthOf(\"%s\") returned %d, expected %d.\n",
+				i, c.key, d, c.uncompressed)
+		}
+	}
+}
+
+func TestCompareTrees(t *testing.T) {
+	keys := []string{"apple", "applepie", "arm", "bee"}
+	compressed, uncompressed := New[int](), New[int](WithoutCompression())
+	for i, key := range keys {
+		compressed.Add(key, i)
+		uncompressed.Add(key, i)
+	}
+
+	c := CompareTrees(compressed, uncompressed)
+	expected := TreeComparison{
+		NodesA: 6, NodesB: 14,
+		MaxDepthA: 3, MaxDepthB: 8,
+		SegmentBytesA: 13, SegmentBytesB: 13,
+		SameKeys: true,
+	}
+	if c != expected {
+		t.Errorf("CompareTrees returned %+v, expected %+v.\n", c, expected)
+	}
+
+	for i, other := range [][]string{
+		{"apple", "applepie", "arm"},
+		{"apple", "applepie", "arm", "bee", "bog"},
+		{"apple", "applepie", "arm", "bed"},
+	} {
+		tree := New[int]()
+		for j, key := range other {
+			tree.Add(key, j)
+		}
+		if c := CompareTrees(compressed, tree); c.SameKeys {
+			t.Errorf("Case %d: CompareTrees reported the same keys for %v and %v.\n",
+				i, keys, other)
+		}
+	}
+}
+
+func TestEstimatedSize(t *testing.T) {
+	nodeSize := int64(unsafe.Sizeof(Tree[string]{}))
+	linkSize := int64(unsafe.Sizeof(link[string]{}))
+
+	tree := New[string]()
+	if size := tree.EstimatedSize(); size != nodeSize {
+		t.Errorf("EstimatedSize() of an empty tree returned %d, expected %d.\n", size, nodeSize)
+	}
+
+	// A single key costs a node, a link and the key's bytes.
+	tree.Add("apple", "red")
+	expected := 2*nodeSize + int64(cap(tree.links))*linkSize + 5
+	if size := tree.EstimatedSize(); size != expected {
+		t.Errorf("EstimatedSize() returned %d, expected %d.\n", size, expected)
+	}
+
+	// The size grows with the keys added.
+	prev := expected
+	for _, key := range []string{"applepie", "arm", "bee"} {
+		tree.Add(key, key)
+		size := tree.EstimatedSize()
+		if size <= prev {
+			t.Errorf("EstimatedSize() returned %d after adding %q, expected more than %d.\n", size, key, prev)
+		}
+		prev = size
+	}
+
+	// A value sizer adds the size of every value.
+	sized := New[string](WithValueSizer(func(v string) int64 { return int64(len(v)) }))
+	unsized := New[string](WithConcurrencyCheck())
+	for _, kv := range tree.FindKeyValues("") {
+		sized.Add(kv.Key, kv.Value)
+		unsized.Add(kv.Key, kv.Value)
+	}
+	valueBytes := int64(len("red") + len("applepie") + len("arm") + len("bee"))
+	if size, expected := sized.EstimatedSize(), unsized.EstimatedSize()+valueBytes; size != expected {
+		t.Errorf("EstimatedSize() with a value sizer returned %d, expected %d.\n", size, expected)
+	}
+
+	// A sizer for a different value type is ignored.
+	ints := New[int](WithValueSizer(func(v string) int64 { return 1000 }))
+	ints.Add("apple", 1)
+	if size := ints.EstimatedSize(); size > 1000 {
+		t.Errorf("EstimatedSize() with a mismatched value sizer returned %d.\n", size)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import "sync"
+
+// A SyncTree is a prefix tree that is safe for concurrent use by multiple
+// goroutines. It wraps a Tree with a read/write mutex: methods that only
+// search the tree hold the read lock, so any number of them may run at once,
+// while methods that modify the tree hold the write lock.
+type SyncTree[V any] struct {
+	mu   sync.RWMutex
+	tree *Tree[V]
+}
+
+// NewSyncTree returns an empty concurrency-safe prefix tree with a value
+// type of V. Options may be provided to configure the underlying tree.
+func NewSyncTree[V any](opts ...Option) *SyncTree[V] {
+	return &SyncTree[V]{tree: New[V](opts...)}
+}
+
+// Add a key string and its associated value data to the prefix tree.
+func (s *SyncTree[V]) Add(key string, value V) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree.Add(key, value)
+}
+
+// Delete removes a key and its associated value from the prefix tree,
+// returning false if the key isn't stored in it.
+func (s *SyncTree[V]) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Delete(key)
+}
+
+// Get returns the value associated with a key that exactly matches a stored
+// key, or false if the key isn't stored in the prefix tree.
+func (s *SyncTree[V]) Get(key string) (V, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Get(key)
+}
+
+// Len returns the number of keys stored in the prefix tree.
+func (s *SyncTree[V]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Len()
+}
+
+// WithReadLock calls fn with the underlying prefix tree while holding the
+// read lock, so that several searches, or a traversal with All, Prefixed or
+// Walk, see the tree in a single consistent state. The lock is released when
+// fn returns, even if it panics. Other goroutines may search the tree while
+// fn runs, so fn must not modify the tree in any way: doing so races with
+// those searches and will corrupt the tree. Nor may fn call methods of the
+// SyncTree that modify it, since they would wait for the read lock forever.
+func (s *SyncTree[V]) WithReadLock(fn func(t *Tree[V])) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.tree)
+}
+
+// FindKey searches the prefix tree for a key string that uniquely matches the
+// prefix. See Tree.FindKey.
+func (s *SyncTree[V]) FindKey(prefix string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.FindKey(prefix)
+}
+
+// FindKeyValue searches the prefix tree for a key string that uniquely
+// matches the prefix. See Tree.FindKeyValue.
+func (s *SyncTree[V]) FindKeyValue(prefix string) (KeyValue[V], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.FindKeyValue(prefix)
+}
+
+// FindKeys searches the prefix tree for all key strings prefixed by the
+// provided prefix. See Tree.FindKeys.
+func (s *SyncTree[V]) FindKeys(prefix string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.FindKeys(prefix)
+}
+
+// FindValue searches the prefix tree for a key string that uniquely matches
+// the prefix. See Tree.FindValue.
+func (s *SyncTree[V]) FindValue(prefix string) (V, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.FindValue(prefix)
+}
+
+// FindKeyValues searches the prefix tree for all key strings prefixed by the
+// provided prefix. See Tree.FindKeyValues.
+func (s *SyncTree[V]) FindKeyValues(prefix string) []KeyValue[V] {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.FindKeyValues(prefix)
+}
+
+// FindValues searches the prefix tree for all key strings prefixed by the
+// provided prefix. See Tree.FindValues.
+func (s *SyncTree[V]) FindValues(prefix string) []V {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.FindValues(prefix)
+}
@@ -0,0 +1,99 @@
+// Copyright 2015-2023 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prefixtree
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSyncTree(t *testing.T) {
+	tree := NewSyncTree[int]()
+	tree.Add("apple", 0)
+
+	// Refresh the tree on one goroutine while others search it.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			tree.Add(fmt.Sprintf("key%d", i), i)
+			if i%10 == 0 {
+				tree.Delete(fmt.Sprintf("key%d", i-5))
+			}
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if value, err := tree.FindValue("ap"); value != 0 || err != nil {
+					t.Errorf("FindValue(\"ap\") returned (%d, %v), expected (0, nil).\n", value, err)
+					return
+				}
+				tree.FindKeys("key1")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := tree.Len(); n != 902 {
+		t.Errorf("Len() returned %d, expected 902.\n", n)
+	}
+	if value, ok := tree.Get("key999"); value != 999 || !ok {
+		t.Errorf("Get(\"key999\") returned (%d, %v), expected (999, true).\n", value, ok)
+	}
+	if _, ok := tree.Get("key985"); ok {
+		t.Errorf("Get(\"key985\") found a deleted key.\n")
+	}
+}
+
+func TestSyncTreeWithReadLock(t *testing.T) {
+	tree := NewSyncTree[int]()
+
+	// Readers see the tree in the same state throughout their callbacks
+	// while a writer keeps changing it.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			tree.Add(fmt.Sprintf("key%03d", i), i)
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				tree.WithReadLock(func(tr *Tree[int]) {
+					n, count := tr.Len(), 0
+					for key, value := range tr.All() {
+						if key != fmt.Sprintf("key%03d", value) {
+							t.Errorf("All() yielded (\"%s\", %d).\n", key, value)
+						}
+						count++
+					}
+					if count != n || tr.Len() != n {
+						t.Errorf("All() yielded %d keys, but Len() returned %d.\n", count, n)
+					}
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The lock is released even if the callback panics.
+	func() {
+		defer func() { recover() }()
+		tree.WithReadLock(func(*Tree[int]) { panic("callback failed") })
+	}()
+	tree.Add("apple", 1)
+	if n := tree.Len(); n != 1001 {
+		t.Errorf("Len() returned %d, expected 1001.\n", n)
+	}
+}